@@ -0,0 +1,127 @@
+package batch
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"load-test/models"
+)
+
+// Queue hands results to a store function in batches, from a goroutine of
+// its own. Write never blocks the caller: when the store falls behind and
+// the queue fills up, results are dropped and counted so recording does
+// not throttle the load.
+type Queue struct {
+	store    func(batch []models.Result) error
+	size     int
+	interval time.Duration
+
+	// mu guards queue against Writes racing Close.
+	mu     sync.RWMutex
+	closed bool
+	queue  chan models.Result
+	done   chan struct{}
+	once   sync.Once
+	// err is the first error store returned.
+	err error
+
+	written atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// New starts a queue of capacity results that stores them size at a time,
+// and, if interval is positive, at least every interval. store must not
+// keep the batch it is given; a batch it fails on counts as dropped.
+func New(capacity, size int, interval time.Duration, store func(batch []models.Result) error) *Queue {
+	if size < 1 {
+		size = 1
+	}
+	q := &Queue{
+		store:    store,
+		size:     size,
+		interval: interval,
+		queue:    make(chan models.Result, capacity),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Write queues r. Results written after Close are dropped.
+func (q *Queue) Write(r models.Result) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		q.dropped.Add(1)
+		return
+	}
+	select {
+	case q.queue <- r:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+// Written and Dropped count the results stored and the ones given up on,
+// whether for a full queue or a failed store.
+func (q *Queue) Written() uint64 {
+	return q.written.Load()
+}
+
+func (q *Queue) Dropped() uint64 {
+	return q.dropped.Load()
+}
+
+// Close stores the queued results and returns the first error a batch met.
+func (q *Queue) Close() error {
+	q.once.Do(func() {
+		q.mu.Lock()
+		q.closed = true
+		close(q.queue)
+		q.mu.Unlock()
+	})
+	<-q.done
+	return q.err
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	var tick <-chan time.Time
+	if q.interval > 0 {
+		ticker := time.NewTicker(q.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	batch := make([]models.Result, 0, q.size)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := q.store(batch); err != nil {
+			if q.err == nil {
+				q.err = err
+			}
+			q.dropped.Add(uint64(len(batch)))
+		} else {
+			q.written.Add(uint64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case r, ok := <-q.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) == q.size {
+				flush()
+			}
+		case <-tick:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package batch
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"load-test/models"
+)
+
+func TestQueueWithoutInterval(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches []int
+	)
+	q := New(10, 4, 0, func(batch []models.Result) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, len(batch))
+		return nil
+	})
+	for i := 0; i < 6; i++ {
+		q.Write(models.Result{})
+	}
+	// Only a full batch is stored before Close.
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	got := append([]int(nil), batches...)
+	mu.Unlock()
+	if want := []int{4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches before Close = %v, want %v", got, want)
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{4, 2}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+	if q.Written() != 6 || q.Dropped() != 0 {
+		t.Errorf("written, dropped = %d, %d, want 6, 0", q.Written(), q.Dropped())
+	}
+}
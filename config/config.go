@@ -0,0 +1,400 @@
+package config
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"load-test/db"
+	"load-test/diameter"
+	"load-test/report"
+)
+
+const envPrefix = "LOADTEST_"
+
+type Peer struct {
+	Address       string        `yaml:"address"`
+	Network       string        `yaml:"network"`
+	OriginHost    string        `yaml:"origin_host"`
+	OriginRealm   string        `yaml:"origin_realm"`
+	HostIPAddress string        `yaml:"host_ip_address"`
+	Connections   int           `yaml:"connections"`
+	Timeout       time.Duration `yaml:"timeout"`
+
+	// Peers replaces Address with several weighted OCS nodes; BackupPeers
+	// only take traffic while none of them is up.
+	Peers       diameter.PeerList `yaml:"peers"`
+	BackupPeers diameter.PeerList `yaml:"backup_peers"`
+	Routing     diameter.Routing  `yaml:"routing"`
+
+	WatchdogInterval time.Duration `yaml:"watchdog_interval"`
+	WatchdogTimeout  time.Duration `yaml:"watchdog_timeout"`
+	WatchdogFailures int           `yaml:"watchdog_failures"`
+
+	TLS TLS `yaml:"tls"`
+}
+
+// TLS holds the certificates used when the peer network is tls.
+type TLS struct {
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	CAFile     string `yaml:"ca_file"`
+	ServerName string `yaml:"server_name"`
+	Insecure   bool   `yaml:"insecure"`
+}
+
+type Subscriber struct {
+	MCC                 string `yaml:"mcc"`
+	MNC                 string `yaml:"mnc"`
+	DataDestinationHost string `yaml:"data_destination_host"`
+	IMSDestinationHost  string `yaml:"ims_destination_host"`
+	IMSIPrefix          string `yaml:"imsi_prefix"`
+	SGSNMCCMNC          string `yaml:"sgsn_mcc_mnc"`
+	MSTimeZone          string `yaml:"ms_timezone"`
+	UserLocationInfo    string `yaml:"user_location_info"`
+	BitrateUL           uint32 `yaml:"bitrate_ul"`
+	BitrateDL           uint32 `yaml:"bitrate_dl"`
+}
+
+type DB struct {
+	DSN string `yaml:"dsn"`
+}
+
+type Run struct {
+	Accounts       int                  `yaml:"accounts"`
+	Concurrency    int                  `yaml:"concurrency"`
+	Profile        string               `yaml:"profile"`
+	Scenario       string               `yaml:"scenario"`
+	Mix            string               `yaml:"mix"`
+	Usage          diameter.UsagePolicy `yaml:"usage"`
+	AbortOnTimeout bool                 `yaml:"abort_on_timeout"`
+	ReportJSON     string               `yaml:"report_json"`
+	Results        string               `yaml:"results"`
+	EmbeddedOCS    bool                 `yaml:"embedded_ocs"`
+	Dashboard      time.Duration        `yaml:"dashboard"`
+	StaleAfter     time.Duration        `yaml:"stale_after"`
+	MetricsAddr    string               `yaml:"metrics_addr"`
+	DrainTimeout   time.Duration        `yaml:"drain_timeout"`
+	Assert         report.Assertions    `yaml:"assert"`
+}
+
+// Stop ends a run early on a limit or an abort threshold; zero values
+// disable a condition. Rates are fractions, e.g. 0.05 for 5%.
+type Stop struct {
+	Duration       time.Duration `yaml:"duration"`
+	Sessions       int           `yaml:"sessions"`
+	Messages       int           `yaml:"messages"`
+	MaxErrorRate   float64       `yaml:"max_error_rate"`
+	MaxTimeoutRate float64       `yaml:"max_timeout_rate"`
+	MaxP99         time.Duration `yaml:"max_p99"`
+	// Window is the period the thresholds are evaluated over.
+	Window time.Duration `yaml:"window"`
+}
+
+// Config is everything a run needs. Values are layered: defaults, then the
+// config file, then LOADTEST_* environment variables, then flags.
+type Config struct {
+	Peer       Peer       `yaml:"peer"`
+	Subscriber Subscriber `yaml:"subscriber"`
+	DB         DB         `yaml:"db"`
+	Run        Run        `yaml:"run"`
+	Stop       Stop       `yaml:"stop"`
+}
+
+func Default() *Config {
+	peer := diameter.DefaultPeerConfig()
+	sub := diameter.DefaultConfig()
+	return &Config{
+		Peer: Peer{
+			Address:       peer.Address,
+			Network:       peer.Network,
+			OriginHost:    peer.OriginHost,
+			OriginRealm:   peer.OriginRealm,
+			HostIPAddress: peer.HostIPAddress,
+			Routing:       peer.Routing,
+			Connections:   4,
+			Timeout:       5 * time.Second,
+
+			WatchdogInterval: peer.WatchdogInterval,
+			WatchdogTimeout:  peer.WatchdogTimeout,
+			WatchdogFailures: peer.WatchdogFailures,
+		},
+		Subscriber: Subscriber{
+			MCC:                sub.MCC,
+			MNC:                sub.MNC,
+			IMSDestinationHost: sub.IMSDestinationHost,
+		},
+		DB: DB{DSN: db.DefaultDSN},
+		Run: Run{
+			Accounts:     1000000,
+			Concurrency:  10000,
+			Usage:        diameter.UsagePolicy{Mode: diameter.UsageFixed},
+			Dashboard:    time.Second,
+			StaleAfter:   time.Minute,
+			DrainTimeout: 10 * time.Second,
+		},
+		Stop: Stop{Window: 10 * time.Second},
+	}
+}
+
+// option binds one config field to a flag and an environment variable.
+// The variable name is the flag name upper-cased with dashes as underscores.
+type option struct {
+	name  string
+	usage string
+	field func(c *Config) interface{}
+}
+
+var options = []option{
+	{"peer-address", "OCS peer address (host:port)", func(c *Config) interface{} { return &c.Peer.Address }},
+	{"peers", "Weighted OCS peers, e.g. 10.0.0.1:3868=3,10.0.0.2:3868 (default: -peer-address)", func(c *Config) interface{} { return &c.Peer.Peers }},
+	{"backup-peers", "Peers that only take traffic while no primary peer is up", func(c *Config) interface{} { return &c.Peer.BackupPeers }},
+	{"routing", "Peer routing: round-robin, weighted or sticky (by Session-Id)", func(c *Config) interface{} { return &c.Peer.Routing }},
+	{"peer-network", "Transport to the peer: tcp, sctp or tls (TLS over TCP, also spelled tls-over-tcp)", func(c *Config) interface{} { return &c.Peer.Network }},
+	{"origin-host", "Origin-Host sent in CER", func(c *Config) interface{} { return &c.Peer.OriginHost }},
+	{"origin-realm", "Origin-Realm sent in CER", func(c *Config) interface{} { return &c.Peer.OriginRealm }},
+	{"host-ip", "Host-IP-Address sent in CER (empty: local address)", func(c *Config) interface{} { return &c.Peer.HostIPAddress }},
+	{"conns", "Number of persistent peer connections", func(c *Config) interface{} { return &c.Peer.Connections }},
+	{"timeout", "Time to wait for a CCA", func(c *Config) interface{} { return &c.Peer.Timeout }},
+	{"watchdog-interval", "Time between DWRs on each peer connection (0: no watchdog)", func(c *Config) interface{} { return &c.Peer.WatchdogInterval }},
+	{"watchdog-timeout", "Time to wait for a DWA", func(c *Config) interface{} { return &c.Peer.WatchdogTimeout }},
+	{"watchdog-failures", "Missed DWAs in a row that take a peer connection down", func(c *Config) interface{} { return &c.Peer.WatchdogFailures }},
+	{"tls-cert", "Client certificate file for tls", func(c *Config) interface{} { return &c.Peer.TLS.CertFile }},
+	{"tls-key", "Client private key file for tls", func(c *Config) interface{} { return &c.Peer.TLS.KeyFile }},
+	{"tls-ca", "CA file to verify the peer certificate against (default: system roots)", func(c *Config) interface{} { return &c.Peer.TLS.CAFile }},
+	{"tls-server-name", "Name expected in the peer certificate (default: the peer host)", func(c *Config) interface{} { return &c.Peer.TLS.ServerName }},
+	{"tls-insecure", "Skip verification of the peer certificate", func(c *Config) interface{} { return &c.Peer.TLS.Insecure }},
+
+	{"mcc", "Mobile Country Code", func(c *Config) interface{} { return &c.Subscriber.MCC }},
+	{"mnc", "Mobile Network Code", func(c *Config) interface{} { return &c.Subscriber.MNC }},
+	{"data-destination-host", "Destination-Host for data CCRs (default derived from MCC/MNC)", func(c *Config) interface{} { return &c.Subscriber.DataDestinationHost }},
+	{"ims-destination-host", "Destination-Host for IMS CCRs", func(c *Config) interface{} { return &c.Subscriber.IMSDestinationHost }},
+	{"imsi-prefix", "Prefix prepended to the account ID for the IMSI Subscription-Id", func(c *Config) interface{} { return &c.Subscriber.IMSIPrefix }},
+	{"sgsn-mcc-mnc", "3GPP-SGSN-MCC-MNC for data CCRs", func(c *Config) interface{} { return &c.Subscriber.SGSNMCCMNC }},
+	{"ms-timezone", "3GPP-MS-TimeZone for data CCRs", func(c *Config) interface{} { return &c.Subscriber.MSTimeZone }},
+	{"user-location-info", "3GPP-User-Location-Info for data CCRs", func(c *Config) interface{} { return &c.Subscriber.UserLocationInfo }},
+	{"bitrate-ul", "APN-Aggregate-Max-Bitrate-UL", func(c *Config) interface{} { return &c.Subscriber.BitrateUL }},
+	{"bitrate-dl", "APN-Aggregate-Max-Bitrate-DL", func(c *Config) interface{} { return &c.Subscriber.BitrateDL }},
+
+	{"db-dsn", "Postgres connection string", func(c *Config) interface{} { return &c.DB.DSN }},
+
+	{"num", "Number of accounts to create", func(c *Config) interface{} { return &c.Run.Accounts }},
+	{"concurrency", "Maximum number of account sessions in flight (0: no limit)", func(c *Config) interface{} { return &c.Run.Concurrency }},
+	{"profile", "Open-loop load profile, e.g. constant:rate=2000,duration=10m (default: one session per account)", func(c *Config) interface{} { return &c.Run.Profile }},
+	{"scenario", "Session flow scenario file (default: one data session with two updates)", func(c *Config) interface{} { return &c.Run.Scenario }},
+	{"mix", "Traffic mix of session flows by weight, e.g. data=70,voice=25,video=5", func(c *Config) interface{} { return &c.Run.Mix }},
+	{"usage", "Used-Service-Unit policy against the last grant: fixed, all, fraction:F, random or exceed:F", func(c *Config) interface{} { return &c.Run.Usage }},
+	{"abort-on-timeout", "Stop a session's flow when one of its requests times out", func(c *Config) interface{} { return &c.Run.AbortOnTimeout }},
+	{"report-json", "Write the run summary as JSON to this file", func(c *Config) interface{} { return &c.Run.ReportJSON }},
+	{"results", "Store every request and its CCA outcome: csv:FILE, jsonl:FILE and/or postgres, comma-separated", func(c *Config) interface{} { return &c.Run.Results }},
+	{"dashboard", "Interval of the live dashboard line on stderr (0: off)", func(c *Config) interface{} { return &c.Run.Dashboard }},
+	{"stale-after", "Time without a request after which an open session counts as stale", func(c *Config) interface{} { return &c.Run.StaleAfter }},
+	{"metrics-addr", "Serve Prometheus metrics on this address at /metrics, e.g. :9100 (empty: off)", func(c *Config) interface{} { return &c.Run.MetricsAddr }},
+	{"drain-timeout", "Time open sessions get to terminate once the run is stopped", func(c *Config) interface{} { return &c.Run.DrainTimeout }},
+	{"assert", "SLA assertions checked at the end of the run, separated by ';', e.g. 'p99(data.update) < 50ms; success_rate > 99.9%; timeouts == 0'", func(c *Config) interface{} { return &c.Run.Assert }},
+	{"embedded-ocs", "Start the built-in fake OCS in-process and point the peer at it", func(c *Config) interface{} { return &c.Run.EmbeddedOCS }},
+
+	{"duration", "Stop the run after this long, cycling through the accounts (0: no limit)", func(c *Config) interface{} { return &c.Stop.Duration }},
+	{"max-sessions", "Stop the run once this many account sessions started, cycling through the accounts (0: no limit)", func(c *Config) interface{} { return &c.Stop.Sessions }},
+	{"max-messages", "Stop the run once this many CCRs were sent (0: no limit)", func(c *Config) interface{} { return &c.Stop.Messages }},
+	{"max-error-rate", "Abort the run when the error rate over the threshold window exceeds this fraction, e.g. 0.05 (0: off)", func(c *Config) interface{} { return &c.Stop.MaxErrorRate }},
+	{"max-timeout-rate", "Abort the run when the timeout rate over the threshold window exceeds this fraction (0: off)", func(c *Config) interface{} { return &c.Stop.MaxTimeoutRate }},
+	{"max-p99", "Abort the run when the p99 latency over the threshold window exceeds this (0: off)", func(c *Config) interface{} { return &c.Stop.MaxP99 }},
+	{"threshold-window", "Period the abort thresholds are evaluated over", func(c *Config) interface{} { return &c.Stop.Window }},
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load builds the config from defaults, the file named by -config (YAML or
+// JSON), the environment and the command line, in increasing precedence.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	path := fs.String("config", os.Getenv(envName("config")), "Path to a YAML or JSON config file")
+
+	// Flags are parsed into a scratch config; only the ones actually set
+	// on the command line are copied over the file and environment values.
+	flagged := Default()
+	for _, o := range options {
+		bindFlag(fs, o, flagged)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if *path != "" {
+		data, err := os.ReadFile(*path)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read config file")
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse config file %s", *path)
+		}
+	}
+
+	for _, o := range options {
+		if v, ok := os.LookupEnv(envName(o.name)); ok {
+			if err := set(o.field(cfg), v); err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", envName(o.name))
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, o := range options {
+			if o.name == f.Name && err == nil {
+				err = set(o.field(cfg), f.Value.String())
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Peer.Network, err = diameter.ParseTransport(cfg.Peer.Network); err != nil {
+		return nil, errors.Wrap(err, "invalid peer network")
+	}
+	if cfg.Subscriber.DataDestinationHost == "" {
+		cfg.Subscriber.DataDestinationHost = fmt.Sprintf(
+			"CGR-DA.epc.mnc%s.mcc%s.3gppnetwork.org",
+			cfg.Subscriber.MNC, cfg.Subscriber.MCC,
+		)
+	}
+	return cfg, nil
+}
+
+func bindFlag(fs *flag.FlagSet, o option, c *Config) {
+	switch p := o.field(c).(type) {
+	case *string:
+		fs.StringVar(p, o.name, *p, o.usage)
+	case *int:
+		fs.IntVar(p, o.name, *p, o.usage)
+	case *uint32:
+		fs.Var(uint32Value{p}, o.name, o.usage)
+	case *float64:
+		fs.Float64Var(p, o.name, *p, o.usage)
+	case *bool:
+		fs.BoolVar(p, o.name, *p, o.usage)
+	case *time.Duration:
+		fs.DurationVar(p, o.name, *p, o.usage)
+	case textValue:
+		fs.TextVar(p, o.name, p, o.usage)
+	default:
+		panic(fmt.Sprintf("config: unsupported option type %T", p))
+	}
+}
+
+type textValue interface {
+	encoding.TextMarshaler
+	encoding.TextUnmarshaler
+}
+
+type uint32Value struct{ p *uint32 }
+
+func (v uint32Value) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return strconv.FormatUint(uint64(*v.p), 10)
+}
+
+func (v uint32Value) Set(s string) error { return set(v.p, s) }
+
+func set(field interface{}, s string) error {
+	switch p := field.(type) {
+	case *string:
+		*p = s
+	case *int:
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *uint32:
+		v, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return err
+		}
+		*p = uint32(v)
+	case *float64:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *bool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case encoding.TextUnmarshaler:
+		return p.UnmarshalText([]byte(s))
+	default:
+		return fmt.Errorf("unsupported option type %T", p)
+	}
+	return nil
+}
+
+func (c *Config) PeerConfig() diameter.PeerConfig {
+	peers := append(diameter.PeerList{}, c.Peer.Peers...)
+	if len(peers) == 0 && len(c.Peer.BackupPeers) > 0 {
+		peers = append(peers, diameter.PeerAddress{Address: c.Peer.Address, Weight: 1})
+	}
+	for _, p := range c.Peer.BackupPeers {
+		p.Backup = true
+		peers = append(peers, p)
+	}
+	return diameter.PeerConfig{
+		Address:       c.Peer.Address,
+		Peers:         peers,
+		Routing:       c.Peer.Routing,
+		Network:       c.Peer.Network,
+		OriginHost:    c.Peer.OriginHost,
+		OriginRealm:   c.Peer.OriginRealm,
+		HostIPAddress: c.Peer.HostIPAddress,
+
+		WatchdogInterval: c.Peer.WatchdogInterval,
+		WatchdogTimeout:  c.Peer.WatchdogTimeout,
+		WatchdogFailures: c.Peer.WatchdogFailures,
+
+		TLS: diameter.TLSConfig{
+			CertFile:           c.Peer.TLS.CertFile,
+			KeyFile:            c.Peer.TLS.KeyFile,
+			CAFile:             c.Peer.TLS.CAFile,
+			ServerName:         c.Peer.TLS.ServerName,
+			InsecureSkipVerify: c.Peer.TLS.Insecure,
+		},
+	}
+}
+
+func (c *Config) DiameterConfig() diameter.Config {
+	return diameter.Config{
+		MNC:                 c.Subscriber.MNC,
+		MCC:                 c.Subscriber.MCC,
+		DataDestinationHost: c.Subscriber.DataDestinationHost,
+		IMSDestinationHost:  c.Subscriber.IMSDestinationHost,
+		PreMiddleFix:        c.Subscriber.IMSIPrefix,
+		Prefix:              c.Subscriber.SGSNMCCMNC,
+		TimeZone:            c.Subscriber.MSTimeZone,
+		UserLocationInfo:    c.Subscriber.UserLocationInfo,
+		BitrateUL:           c.Subscriber.BitrateUL,
+		BitrateDL:           c.Subscriber.BitrateDL,
+		Usage:               c.Run.Usage,
+	}
+}
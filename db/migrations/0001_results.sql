@@ -0,0 +1,30 @@
+CREATE TABLE runs
+(
+    id          UUID PRIMARY KEY,
+    started_at  TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ,
+    description TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE TABLE results
+(
+    id                    BIGSERIAL PRIMARY KEY,
+    run_id                UUID        NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+    account_id            TEXT        NOT NULL,
+    session_id            TEXT        NOT NULL,
+    message_type          TEXT        NOT NULL,
+    request_number        INTEGER     NOT NULL,
+    sent_at               TIMESTAMPTZ NOT NULL,
+    -- NULL when no CCA arrived.
+    received_at           TIMESTAMPTZ,
+    latency_us            BIGINT,
+    result_code           INTEGER,
+    granted_time          BIGINT      NOT NULL DEFAULT 0,
+    granted_total_octets  BIGINT      NOT NULL DEFAULT 0,
+    granted_input_octets  BIGINT      NOT NULL DEFAULT 0,
+    granted_output_octets BIGINT      NOT NULL DEFAULT 0,
+    error                 TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX results_run_type_idx ON results (run_id, message_type);
+CREATE INDEX results_run_session_idx ON results (run_id, session_id);
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"load-test/batch"
+	"load-test/models"
+)
+
+const (
+	// DefaultBatchSize is the number of results written per COPY.
+	DefaultBatchSize = 5000
+	// DefaultFlushInterval bounds how long a result waits in a batch.
+	DefaultFlushInterval = time.Second
+)
+
+var resultColumns = []string{
+	"run_id", "account_id", "session_id", "message_type", "request_number",
+	"sent_at", "received_at", "latency_us", "result_code",
+	"granted_time", "granted_total_octets", "granted_input_octets", "granted_output_octets",
+	"error",
+}
+
+// Writer stores the results of a run in batches, each with one COPY, from a
+// goroutine of its own. Write never blocks the caller: when the database
+// falls more than a few batches behind, results are dropped and counted so
+// recording does not throttle the load.
+type Writer struct {
+	*batch.Queue
+	db    *DB
+	runID string
+}
+
+func (d *DB) NewWriter(runID string, batchSize int, flushInterval time.Duration) *Writer {
+	return newWriter(d, runID, batchSize, flushInterval, (*Writer).copy)
+}
+
+// newWriter is NewWriter with store, copy outside of tests, writing each
+// batch.
+func newWriter(d *DB, runID string, batchSize int, flushInterval time.Duration, store func(*Writer, []models.Result) error) *Writer {
+	if batchSize < 1 {
+		batchSize = DefaultBatchSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	w := &Writer{db: d, runID: runID}
+	w.Queue = batch.New(4*batchSize, batchSize, flushInterval, func(results []models.Result) error {
+		err := store(w, results)
+		if err != nil {
+			log.Errorf("unable to store %d results: %v", len(results), err)
+		}
+		return err
+	})
+	return w
+}
+
+func (w *Writer) RunID() string {
+	return w.runID
+}
+
+func (w *Writer) copy(batch []models.Result) error {
+	tx, err := w.db.sql.Begin()
+	if err != nil {
+		return errors.Wrap(err, "unable to begin transaction")
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(pq.CopyIn("results", resultColumns...))
+	if err != nil {
+		return errors.Wrap(err, "unable to start COPY")
+	}
+	for _, r := range batch {
+		var received, latency, code interface{}
+		if !r.Received.IsZero() {
+			received = r.Received
+			latency = r.Latency.Microseconds()
+		}
+		if r.ResultCode != 0 {
+			code = int64(r.ResultCode)
+		}
+		_, err := stmt.Exec(
+			w.runID, r.AccountID.String(), r.SessionID, r.Type.String(), int64(r.RequestNumber),
+			r.Sent, received, latency, code,
+			int64(r.GrantedTime), int64(r.GrantedTotalOctets), int64(r.GrantedInputOctets), int64(r.GrantedOutputOctets),
+			r.Err,
+		)
+		if err != nil {
+			stmt.Close()
+			return errors.Wrap(err, "unable to copy result")
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return errors.Wrap(err, "unable to finish COPY")
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
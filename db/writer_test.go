@@ -0,0 +1,162 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"load-test/models"
+)
+
+// store records the sizes of the batches a Writer stores. Until release is
+// closed, each batch waits in Store for it.
+type store struct {
+	mu      sync.Mutex
+	batches []int
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func newStore() *store {
+	s := &store{started: make(chan struct{}, 100), release: make(chan struct{})}
+	close(s.release)
+	return s
+}
+
+func (s *store) Store(w *Writer, batch []models.Result) error {
+	s.started <- struct{}{}
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, len(batch))
+	return s.err
+}
+
+func (s *store) Batches() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.batches...)
+}
+
+func testWriter(s *store, batchSize int, flushInterval time.Duration) *Writer {
+	return newWriter(nil, "run", batchSize, flushInterval, s.Store)
+}
+
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not within %v", what, timeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriterFlushesFullBatches(t *testing.T) {
+	s := newStore()
+	w := testWriter(s, 3, time.Hour)
+	for i := 0; i < 7; i++ {
+		w.Write(models.Result{})
+	}
+	waitFor(t, time.Second, "two full batches", func() bool { return len(s.Batches()) == 2 })
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.Batches(), []int{3, 3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+	if w.Written() != 7 || w.Dropped() != 0 {
+		t.Errorf("written, dropped = %d, %d, want 7, 0", w.Written(), w.Dropped())
+	}
+}
+
+func TestWriterFlushesOnInterval(t *testing.T) {
+	s := newStore()
+	w := testWriter(s, 100, 20*time.Millisecond)
+	defer w.Close()
+	w.Write(models.Result{})
+	w.Write(models.Result{})
+	waitFor(t, time.Second, "a flush", func() bool { return w.Written() == 2 })
+	if got, want := s.Batches(), []int{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestWriterDropsWhenFull(t *testing.T) {
+	s := newStore()
+	s.release = make(chan struct{})
+	// A batch of one and a queue of four.
+	w := testWriter(s, 1, time.Hour)
+	w.Write(models.Result{})
+	<-s.started
+	for i := 0; i < 7; i++ {
+		w.Write(models.Result{})
+	}
+	if got := w.Dropped(); got != 3 {
+		t.Errorf("Dropped() = %d, want 3", got)
+	}
+	close(s.release)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Written(); got != 5 {
+		t.Errorf("Written() = %d, want 5", got)
+	}
+}
+
+func TestWriterDropsAfterClose(t *testing.T) {
+	s := newStore()
+	w := testWriter(s, 10, time.Hour)
+	w.Write(models.Result{})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write(models.Result{})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Written() != 1 || w.Dropped() != 1 {
+		t.Errorf("written, dropped = %d, %d, want 1, 1", w.Written(), w.Dropped())
+	}
+}
+
+func TestWriterStoreError(t *testing.T) {
+	s := newStore()
+	s.err = errors.New("COPY failed")
+	w := testWriter(s, 2, time.Hour)
+	for i := 0; i < 3; i++ {
+		w.Write(models.Result{})
+	}
+	if err := w.Close(); err != s.err {
+		t.Errorf("Close() = %v, want %v", err, s.err)
+	}
+	if w.Written() != 0 || w.Dropped() != 3 {
+		t.Errorf("written, dropped = %d, %d, want 0, 3", w.Written(), w.Dropped())
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	list, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 {
+		t.Fatal("no migrations")
+	}
+	if list[0].version != 1 || list[0].name != "0001_results" {
+		t.Errorf("first migration = %d %s, want 1 0001_results", list[0].version, list[0].name)
+	}
+	if !strings.Contains(list[0].sql, "CREATE TABLE results") {
+		t.Errorf("migration %s does not create the results table", list[0].name)
+	}
+	for i := 1; i < len(list); i++ {
+		if list[i].version <= list[i-1].version {
+			t.Errorf("migration %s follows %s", list[i].name, list[i-1].name)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package diameter
+
+import (
+	"sync"
+
+	"github.com/MHG14/go-diameter/v4/diam"
+	"github.com/MHG14/go-diameter/v4/diam/avp"
+	"github.com/MHG14/go-diameter/v4/diam/datatype"
+	log "github.com/sirupsen/logrus"
+)
+
+type asr struct {
+	SessionID string `avp:"Session-Id"`
+}
+
+// handleASR answers an Abort-Session-Request (RFC 6733 8.5) and marks the
+// session aborted, so its flow stops sending updates for it.
+func handleASR(peer PeerConfig, sessions *sync.Map) diam.HandlerFunc {
+	return func(c diam.Conn, m *diam.Message) {
+		req := asr{}
+		if err := m.Unmarshal(&req); err != nil {
+			log.Errorf("unable to decode ASR: %v", err)
+			return
+		}
+
+		code := uint32(ResultSuccess)
+		val, ok := sessions.Load(req.SessionID)
+		if !ok {
+			log.Warnf("Received ASR for unknown session %s", req.SessionID)
+			code = ResultUnknownSessionID
+		}
+
+		a := m.Answer(code)
+		a.NewAVP(avp.SessionID, avp.Mbit, 0, datatype.UTF8String(req.SessionID))
+		a.NewAVP(avp.OriginHost, avp.Mbit, 0, datatype.DiameterIdentity(peer.OriginHost))
+		a.NewAVP(avp.OriginRealm, avp.Mbit, 0, datatype.DiameterIdentity(peer.OriginRealm))
+		if _, err := a.WriteTo(c); err != nil {
+			log.Errorf("unable to write ASA: %v", err)
+		}
+
+		if ok {
+			val.(*Session).abort()
+		}
+	}
+}
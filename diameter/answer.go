@@ -0,0 +1,232 @@
+package diameter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MHG14/go-diameter/v4/diam"
+	"github.com/pkg/errors"
+	"load-test/models"
+)
+
+// Result codes from RFC 6733 and RFC 4006 that show up in CCAs.
+const (
+	ResultSuccess                    = 2001
+	ResultLimitedSuccess             = 2002
+	ResultUnableToDeliver            = 3002
+	ResultTooBusy                    = 3004
+	ResultEndUserServiceDenied       = 4010
+	ResultCreditControlNotApplicable = 4011
+	ResultCreditLimitReached         = 4012
+	ResultUnknownSessionID           = 5002
+	ResultAuthorizationRejected      = 5003
+	ResultUnableToComply             = 5012
+	ResultUserUnknown                = 5030
+	ResultRatingFailed               = 5031
+)
+
+var resultCodeNames = map[uint32]string{
+	ResultSuccess:                    "DIAMETER_SUCCESS",
+	ResultLimitedSuccess:             "DIAMETER_LIMITED_SUCCESS",
+	ResultUnableToDeliver:            "DIAMETER_UNABLE_TO_DELIVER",
+	ResultTooBusy:                    "DIAMETER_TOO_BUSY",
+	ResultEndUserServiceDenied:       "DIAMETER_END_USER_SERVICE_DENIED",
+	ResultCreditControlNotApplicable: "DIAMETER_CREDIT_CONTROL_NOT_APPLICABLE",
+	ResultCreditLimitReached:         "DIAMETER_CREDIT_LIMIT_REACHED",
+	ResultUnknownSessionID:           "DIAMETER_UNKNOWN_SESSION_ID",
+	ResultAuthorizationRejected:      "DIAMETER_AUTHORIZATION_REJECTED",
+	ResultUnableToComply:             "DIAMETER_UNABLE_TO_COMPLY",
+	ResultUserUnknown:                "DIAMETER_USER_UNKNOWN",
+	ResultRatingFailed:               "DIAMETER_RATING_FAILED",
+}
+
+func ResultCodeName(code uint32) string {
+	if name, ok := resultCodeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("RESULT_CODE_%d", code)
+}
+
+// IsSuccess reports whether code is in the 2xxx success class.
+func IsSuccess(code uint32) bool {
+	return code >= 2000 && code < 3000
+}
+
+type GrantedServiceUnit struct {
+	Time         uint32
+	TotalOctets  uint64
+	InputOctets  uint64
+	OutputOctets uint64
+}
+
+// Final-Unit-Action values (RFC 4006 8.35).
+const (
+	FinalUnitTerminate      uint32 = 0
+	FinalUnitRedirect       uint32 = 1
+	FinalUnitRestrictAccess uint32 = 2
+)
+
+// FinalUnit is a Final-Unit-Indication: the granted units are the last ones
+// and Action says what to do once they are used up.
+type FinalUnit struct {
+	Action          uint32
+	RedirectAddress string
+}
+
+func (f FinalUnit) String() string {
+	switch f.Action {
+	case FinalUnitTerminate:
+		return "terminate"
+	case FinalUnitRedirect:
+		return "redirect"
+	case FinalUnitRestrictAccess:
+		return "restrict"
+	}
+	return fmt.Sprintf("action_%d", f.Action)
+}
+
+// ServiceCredit is one Multiple-Services-Credit-Control entry of a CCA.
+type ServiceCredit struct {
+	RatingGroup       uint32
+	ServiceIdentifier uint32
+	ResultCode        uint32
+	Granted           GrantedServiceUnit
+	ValidityTime      time.Duration
+	// FinalUnit is set when the entry carries a Final-Unit-Indication.
+	FinalUnit *FinalUnit
+}
+
+// Answer is the decoded outcome of a CCA.
+type Answer struct {
+	SessionID              string
+	RequestType            uint32
+	RequestNumber          uint32
+	ResultCode             uint32
+	ExperimentalResultCode uint32
+	Services               []ServiceCredit
+}
+
+// FinalUnit returns the first Final-Unit-Indication of the answer.
+func (a *Answer) FinalUnit() (FinalUnit, bool) {
+	for _, sc := range a.Services {
+		if sc.FinalUnit != nil {
+			return *sc.FinalUnit, true
+		}
+	}
+	return FinalUnit{}, false
+}
+
+// CreditLimitReached reports whether the answer, or one of its MSCCs, is
+// DIAMETER_CREDIT_LIMIT_REACHED.
+func (a *Answer) CreditLimitReached() bool {
+	if a.Code() == ResultCreditLimitReached {
+		return true
+	}
+	for _, sc := range a.Services {
+		if sc.ResultCode == ResultCreditLimitReached {
+			return true
+		}
+	}
+	return false
+}
+
+// Code returns Result-Code, or Experimental-Result-Code when the OCS only
+// sent the latter.
+func (a *Answer) Code() uint32 {
+	if a.ResultCode != 0 {
+		return a.ResultCode
+	}
+	return a.ExperimentalResultCode
+}
+
+// ResultError is returned when a CCA carries a non-2xxx result.
+type ResultError struct {
+	Code   uint32
+	Answer *Answer
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("CCA result code %d (%s)", e.Code, ResultCodeName(e.Code))
+}
+
+// TimeoutError is returned when no CCA arrives within the client timeout.
+type TimeoutError struct {
+	Type      models.MessageType
+	AccountID models.AccountID
+	After     time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout happened on accountID: %s after %v", e.Type, e.AccountID, e.After)
+}
+
+func IsTimeout(err error) bool {
+	var t *TimeoutError
+	return errors.As(err, &t)
+}
+
+type grantedServiceUnitAVP struct {
+	Time         uint32 `avp:"CC-Time"`
+	TotalOctets  uint64 `avp:"CC-Total-Octets"`
+	InputOctets  uint64 `avp:"CC-Input-Octets"`
+	OutputOctets uint64 `avp:"CC-Output-Octets"`
+}
+
+type finalUnitAVP struct {
+	Action         uint32 `avp:"Final-Unit-Action"`
+	RedirectServer struct {
+		Address string `avp:"Redirect-Server-Address"`
+	} `avp:"Redirect-Server"`
+}
+
+type msccAVP struct {
+	RatingGroup       uint32                `avp:"Rating-Group"`
+	ServiceIdentifier uint32                `avp:"Service-Identifier"`
+	ResultCode        uint32                `avp:"Result-Code"`
+	Granted           grantedServiceUnitAVP `avp:"Granted-Service-Unit"`
+	ValidityTime      uint32                `avp:"Validity-Time"`
+	FinalUnit         *finalUnitAVP         `avp:"Final-Unit-Indication"`
+}
+
+type CCAMessage struct {
+	SessionID          string `avp:"Session-Id"`
+	RequestType        uint32 `avp:"CC-Request-Type"`
+	RequestNumber      uint32 `avp:"CC-Request-Number"`
+	ResultCode         uint32 `avp:"Result-Code"`
+	ExperimentalResult struct {
+		Code uint32 `avp:"Experimental-Result-Code"`
+	} `avp:"Experimental-Result"`
+	MSCC []msccAVP `avp:"Multiple-Services-Credit-Control"`
+}
+
+// ParseAnswer decodes the fields of a CCA the load tester acts on.
+func ParseAnswer(m *diam.Message) (*Answer, error) {
+	message := CCAMessage{}
+	if err := m.Unmarshal(&message); err != nil {
+		return nil, err
+	}
+	a := &Answer{
+		SessionID:              message.SessionID,
+		RequestType:            message.RequestType,
+		RequestNumber:          message.RequestNumber,
+		ResultCode:             message.ResultCode,
+		ExperimentalResultCode: message.ExperimentalResult.Code,
+	}
+	for _, mscc := range message.MSCC {
+		sc := ServiceCredit{
+			RatingGroup:       mscc.RatingGroup,
+			ServiceIdentifier: mscc.ServiceIdentifier,
+			ResultCode:        mscc.ResultCode,
+			Granted:           GrantedServiceUnit(mscc.Granted),
+			ValidityTime:      time.Duration(mscc.ValidityTime) * time.Second,
+		}
+		if mscc.FinalUnit != nil {
+			sc.FinalUnit = &FinalUnit{
+				Action:          mscc.FinalUnit.Action,
+				RedirectAddress: mscc.FinalUnit.RedirectServer.Address,
+			}
+		}
+		a.Services = append(a.Services, sc)
+	}
+	return a, nil
+}
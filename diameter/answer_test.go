@@ -0,0 +1,168 @@
+package diameter
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MHG14/go-diameter/v4/diam"
+	"github.com/MHG14/go-diameter/v4/diam/avp"
+	"github.com/MHG14/go-diameter/v4/diam/datatype"
+	"github.com/MHG14/go-diameter/v4/diam/dict"
+	"load-test/models"
+	"load-test/ocs"
+	"load-test/report"
+)
+
+func grouped(avps ...*diam.AVP) *diam.GroupedAVP {
+	return &diam.GroupedAVP{AVP: avps}
+}
+
+// cca builds a CCA out of avps and sends it through the wire format.
+func cca(t *testing.T, avps ...*diam.AVP) *diam.Message {
+	t.Helper()
+	m := diam.NewMessage(diam.CreditControl, 0, 4, 1, 1, dict.Default)
+	m.NewAVP(avp.SessionID, avp.Mbit, 0, datatype.UTF8String("answer-test;1"))
+	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(2))
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(3))
+	for _, a := range avps {
+		m.AddAVP(a)
+	}
+	b, err := m.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = diam.ReadMessage(bytes.NewReader(b), dict.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestParseAnswer(t *testing.T) {
+	m := cca(t,
+		diam.NewAVP(avp.ResultCode, avp.Mbit, 0, datatype.Unsigned32(ResultSuccess)),
+		diam.NewAVP(avp.MultipleServicesCreditControl, avp.Mbit, 0, grouped(
+			diam.NewAVP(avp.GrantedServiceUnit, avp.Mbit, 0, grouped(
+				diam.NewAVP(avp.CCTime, avp.Mbit, 0, datatype.Unsigned32(600)),
+				diam.NewAVP(avp.CCTotalOctets, avp.Mbit, 0, datatype.Unsigned64(1<<20)),
+				diam.NewAVP(avp.CCInputOctets, avp.Mbit, 0, datatype.Unsigned64(1<<10)),
+				diam.NewAVP(avp.CCOutputOctets, avp.Mbit, 0, datatype.Unsigned64(1<<19)),
+			)),
+			diam.NewAVP(avp.RatingGroup, avp.Mbit, 0, datatype.Unsigned32(10)),
+			diam.NewAVP(avp.ServiceIdentifier, avp.Mbit, 0, datatype.Unsigned32(1)),
+			diam.NewAVP(avp.ValidityTime, avp.Mbit, 0, datatype.Unsigned32(30)),
+			diam.NewAVP(avp.ResultCode, avp.Mbit, 0, datatype.Unsigned32(ResultSuccess)),
+		)),
+		diam.NewAVP(avp.MultipleServicesCreditControl, avp.Mbit, 0, grouped(
+			diam.NewAVP(avp.RatingGroup, avp.Mbit, 0, datatype.Unsigned32(20)),
+			diam.NewAVP(avp.ResultCode, avp.Mbit, 0, datatype.Unsigned32(ResultCreditLimitReached)),
+		)),
+		diam.NewAVP(avp.MultipleServicesCreditControl, avp.Mbit, 0, grouped(
+			diam.NewAVP(avp.RatingGroup, avp.Mbit, 0, datatype.Unsigned32(30)),
+			diam.NewAVP(avp.ResultCode, avp.Mbit, 0, datatype.Unsigned32(ResultSuccess)),
+			diam.NewAVP(avp.FinalUnitIndication, avp.Mbit, 0, grouped(
+				diam.NewAVP(avp.FinalUnitAction, avp.Mbit, 0, datatype.Enumerated(FinalUnitRedirect)),
+				diam.NewAVP(avp.RedirectServer, avp.Mbit, 0, grouped(
+					diam.NewAVP(avp.RedirectAddressType, avp.Mbit, 0, datatype.Enumerated(2)),
+					diam.NewAVP(avp.RedirectServerAddress, avp.Mbit, 0, datatype.UTF8String("http://topup.example")),
+				)),
+			)),
+		)),
+	)
+
+	a, err := ParseAnswer(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.SessionID != "answer-test;1" || a.RequestType != 2 || a.RequestNumber != 3 {
+		t.Errorf("answer %q type %d number %d, want answer-test;1, 2 and 3", a.SessionID, a.RequestType, a.RequestNumber)
+	}
+	if a.Code() != ResultSuccess {
+		t.Errorf("Code() = %d, want %d", a.Code(), ResultSuccess)
+	}
+	want := []ServiceCredit{
+		{
+			RatingGroup:       10,
+			ServiceIdentifier: 1,
+			ResultCode:        ResultSuccess,
+			Granted:           GrantedServiceUnit{Time: 600, TotalOctets: 1 << 20, InputOctets: 1 << 10, OutputOctets: 1 << 19},
+			ValidityTime:      30 * time.Second,
+		},
+		{RatingGroup: 20, ResultCode: ResultCreditLimitReached},
+		{RatingGroup: 30, ResultCode: ResultSuccess, FinalUnit: &FinalUnit{Action: FinalUnitRedirect, RedirectAddress: "http://topup.example"}},
+	}
+	if len(a.Services) != len(want) {
+		t.Fatalf("answer has %d MSCCs, want %d", len(a.Services), len(want))
+	}
+	for i, sc := range a.Services {
+		fui, wantFUI := sc.FinalUnit, want[i].FinalUnit
+		sc.FinalUnit, want[i].FinalUnit = nil, nil
+		if sc != want[i] {
+			t.Errorf("MSCC %d = %+v, want %+v", i, sc, want[i])
+		}
+		if (fui == nil) != (wantFUI == nil) || fui != nil && *fui != *wantFUI {
+			t.Errorf("MSCC %d final unit = %v, want %v", i, fui, wantFUI)
+		}
+	}
+	if !a.CreditLimitReached() {
+		t.Error("CreditLimitReached() = false with a 4012 MSCC, want true")
+	}
+	if fui, ok := a.FinalUnit(); !ok || fui.Action != FinalUnitRedirect {
+		t.Errorf("FinalUnit() = %v, %v, want redirect", fui, ok)
+	}
+}
+
+func TestParseAnswerExperimentalResult(t *testing.T) {
+	m := cca(t, diam.NewAVP(avp.ExperimentalResult, avp.Mbit, 0, grouped(
+		diam.NewAVP(avp.VendorID, avp.Mbit, 0, datatype.Unsigned32(10415)),
+		diam.NewAVP(avp.ExperimentalResultCode, avp.Mbit, 0, datatype.Unsigned32(ResultUserUnknown)),
+	)))
+	a, err := ParseAnswer(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ResultCode != 0 || a.ExperimentalResultCode != ResultUserUnknown || a.Code() != ResultUserUnknown {
+		t.Errorf("answer result %d, experimental %d, Code() %d, want the experimental %d",
+			a.ResultCode, a.ExperimentalResultCode, a.Code(), ResultUserUnknown)
+	}
+	if a.CreditLimitReached() {
+		t.Error("CreditLimitReached() = true, want false")
+	}
+	if _, ok := a.FinalUnit(); ok {
+		t.Error("FinalUnit() found one in an answer without MSCCs")
+	}
+}
+
+func TestResultError(t *testing.T) {
+	settings := ocs.DefaultSettings()
+	settings.ErrorRate = 1
+	settings.ErrorCode = ResultUserUnknown
+	_, addr := startOCS(t, settings)
+
+	hopIDs, sessions := new(sync.Map), new(sync.Map)
+	recorder := report.NewRecorder()
+	router, err := NewRouter(tcpPeer(addr), 1, hopIDs, sessions, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+	client := NewDiameterClient(router, hopIDs, sessions, 5*time.Second, recorder, DefaultConfig(), nil, nil, nil)
+
+	answer, err := client.InitData(models.NewAccountID(1), NewSession("result-error-test"))
+	var re *ResultError
+	if !errors.As(err, &re) {
+		t.Fatalf("CCR-I answered %v, want a *ResultError", err)
+	}
+	if re.Code != ResultUserUnknown || re.Answer != answer || answer.Code() != ResultUserUnknown {
+		t.Errorf("ResultError code %d, answer %+v, want %d and the answer returned", re.Code, re.Answer, ResultUserUnknown)
+	}
+	if want := "CCA result code 5030 (DIAMETER_USER_UNKNOWN)"; re.Error() != want {
+		t.Errorf("Error() = %q, want %q", re.Error(), want)
+	}
+	if IsTimeout(err) {
+		t.Error("IsTimeout = true for a ResultError")
+	}
+}
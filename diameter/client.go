@@ -2,52 +2,112 @@ package diameter
 
 import (
 	"github.com/MHG14/go-diameter/v4/diam"
+	"github.com/MHG14/go-diameter/v4/diam/avp"
+	"github.com/MHG14/go-diameter/v4/diam/datatype"
 	"github.com/pkg/errors"
+	"load-test/metrics"
 	"load-test/models"
+	"load-test/monitoring"
+	"load-test/report"
+	"load-test/sink"
 	"sync"
 	"time"
 )
 
 type Client interface {
-	InitData(accountID models.AccountID, sessionID string) error
-	UpdateData(accountID models.AccountID, sessionID string) error
-	TerminateData(accountID models.AccountID, sessionID string) error
+	InitData(accountID models.AccountID, session *Session) (*Answer, error)
+	UpdateData(accountID models.AccountID, session *Session) (*Answer, error)
+	TerminateData(accountID models.AccountID, session *Session) (*Answer, error)
 
-	InitVideoCalling(accountID0, accountID1 models.AccountID, sessionID string) error
-	UpdateVideoCalling(accountID0, accountID1 models.AccountID, sessionID string) error
-	TerminateVideoCalling(accountID0, accountID1 models.AccountID, sessionID string) error
+	InitVideoCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error)
+	UpdateVideoCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error)
+	TerminateVideoCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error)
 
-	InitVoiceCalling(accountID0, accountID1 models.AccountID, sessionID string) error
-	UpdateVoiceCalling(accountID0, accountID1 models.AccountID, sessionID string) error
-	TerminateVoiceCalling(accountID0, accountID1 models.AccountID, sessionID string) error
-	InitVoiceCalled(accountID0, accountID1 models.AccountID, sessionID string) error
-	UpdateVoiceCalled(accountID0, accountID1 models.AccountID, sessionID string) error
-	TerminateVoiceCalled(accountID0, accountID1 models.AccountID, sessionID string) error
+	InitVoiceCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error)
+	UpdateVoiceCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error)
+	TerminateVoiceCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error)
+	InitVoiceCalled(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error)
+	UpdateVoiceCalled(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error)
+	TerminateVoiceCalled(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error)
 }
 
 type DiameterClient struct {
-	timeout time.Duration
-	conn    diam.Conn
+	timeout  time.Duration
+	router   *Router
+	recorder *report.Recorder
+	cfg      Config
+	// results, when set, stores the outcome of every request.
+	results sink.ResultSink
+	tracker *monitoring.Tracker
+	metrics *metrics.Metrics
 
 	hopIDs *sync.Map
+	// sessions maps the wire Session-Id of each open session to it, so
+	// server-initiated requests can find it.
+	sessions *sync.Map
 	//mux  *sm.StateMachine
 }
 
-func (d *DiameterClient) Send(message *diam.Message, accountID models.AccountID) error {
+// Send writes message and waits for its answer. Units granted by the
+// answer are kept on session for the next request's usage report.
+func (d *DiameterClient) Send(messageType models.MessageType, message *diam.Message, accountID models.AccountID, session *Session) (*Answer, error) {
+	wireID := wireSessionID(message)
+	d.sessions.Store(wireID, session)
+	result := models.Result{
+		AccountID:     accountID,
+		SessionID:     wireID,
+		Type:          messageType,
+		RequestNumber: requestNumber(message),
+	}
+	if d.tracker != nil {
+		d.tracker.Sent(accountID, wireID, messageType)
+	}
+	d.metrics.Sent(messageType)
+	answer, err := d.send(messageType, message, accountID, wireID, session, &result)
+	if err != nil {
+		result.Err = err.Error()
+	}
+	timeout := IsTimeout(err)
+	if d.tracker != nil {
+		d.tracker.Done(result, timeout)
+	}
+	d.metrics.Done(result, timeout)
+	if d.results != nil {
+		d.results.Write(result)
+	}
+	if messageType.Request == models.RequestTerminate || (messageType.Request == models.RequestInit && err != nil && !timeout) {
+		// Sessions that ended, or were refused, get no more RARs or ASRs.
+		// One whose CCR-I timed out may be open on the OCS all the same.
+		d.sessions.Delete(wireID)
+	}
+	return answer, err
+}
+
+func (d *DiameterClient) send(messageType models.MessageType, message *diam.Message, accountID models.AccountID, wireID string, session *Session, result *models.Result) (*Answer, error) {
 	hopID := message.Header.HopByHopID
-	ch := make(chan *diam.Message)
+	ch := make(chan *diam.Message, 1)
 
 	d.hopIDs.Store(hopID, ch)
 
-	conn, err := NewConnection(d.hopIDs)
+	// A request no peer could take is still stored, as sent now.
+	result.Sent = time.Now()
+	conn, peer, err := d.router.Get(wireID)
 	if err != nil {
-		panic(errors.Wrap(err, "unable to connect to diameter in send function"))
+		d.hopIDs.Delete(hopID)
+		d.recorder.Record(report.Sample{Type: messageType, Peer: peer, Err: err})
+		return nil, err
 	}
 
-	defer conn.Close()
+	sent := time.Now()
+	result.Sent = sent
 	_, err = message.WriteTo(conn)
 	if err != nil {
-		return err
+		d.hopIDs.Delete(hopID)
+		// Let the pool redial this peer.
+		conn.Close()
+		err = errors.Wrap(err, "unable to write request")
+		d.recorder.Record(report.Sample{Type: messageType, Peer: peer, Err: err})
+		return nil, err
 	}
 
 	timeout := time.After(d.timeout)
@@ -55,69 +115,153 @@ func (d *DiameterClient) Send(message *diam.Message, accountID models.AccountID)
 	// Wait for Response
 	select {
 	case resp := <-ch:
-		_ = resp
+		latency := time.Since(sent)
 		d.hopIDs.Delete(hopID)
+		result.Received = sent.Add(latency)
+		result.Latency = latency
 
-		return nil
+		answer, err := ParseAnswer(resp)
+		if err != nil {
+			err = errors.Wrap(err, "unable to decode CCA")
+			d.recorder.Record(report.Sample{Type: messageType, Peer: peer, Latency: latency, Answered: true, Err: err})
+			return nil, err
+		}
+		if !IsSuccess(answer.Code()) {
+			err = &ResultError{Code: answer.Code(), Answer: answer}
+		}
+		session.Grant(answer)
+		fillResult(result, answer)
+		d.recorder.Record(report.Sample{
+			Type:               messageType,
+			Peer:               peer,
+			Latency:            latency,
+			Answered:           true,
+			ResultCode:         answer.Code(),
+			ServiceResultCodes: serviceResultCodes(answer),
+			Err:                err,
+		})
+		return answer, err
 	case <-timeout:
 		d.hopIDs.Delete(hopID)
-		//return errors.New(fmt.Sprintf("Timeout happened on accountID: %s", accountID.String()))
-		return nil
+		err := &TimeoutError{Type: messageType, AccountID: accountID, After: d.timeout}
+		d.recorder.Record(report.Sample{Type: messageType, Peer: peer, Timeout: true, Err: err})
+		return nil, err
 	}
 }
 
-func (d *DiameterClient) InitData(accountID models.AccountID, sessionID string) error {
-	return d.Send(BuildDataInitSessionCCR(sessionID, accountID.String()), accountID)
+func (d *DiameterClient) InitData(accountID models.AccountID, session *Session) (*Answer, error) {
+	return d.Send(models.DataInit, BuildDataInitSessionCCR(d.cfg, session.ID, session.Next(), accountID.String()), accountID, session)
+}
+
+func (d *DiameterClient) UpdateData(accountID models.AccountID, session *Session) (*Answer, error) {
+	return d.Send(models.DataUpdate, BuildDataUpdateSessionCCR(d.cfg, session.ID, session.Next(), d.usage(session, fixedDataUpdateUsage), accountID.String()), accountID, session)
 }
 
-func (d *DiameterClient) UpdateData(accountID models.AccountID, sessionID string) error {
-	return d.Send(BuildDataUpdateSessionCCR(sessionID, accountID.String()), accountID)
+func (d *DiameterClient) TerminateData(accountID models.AccountID, session *Session) (*Answer, error) {
+	used, cause := d.termination(session, fixedDataTerminateUsage)
+	return d.Send(models.DataTerminate, BuildDataTerminateSessionCCR(d.cfg, session.ID, session.Next(), used, cause, accountID.String()), accountID, session)
 }
 
-func (d *DiameterClient) TerminateData(accountID models.AccountID, sessionID string) error {
-	return d.Send(BuildDataTerminateSessionCCR(sessionID, accountID.String()), accountID)
+func (d *DiameterClient) InitVideoCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error) {
+	return d.Send(models.VideoCallingInit, BuildVideoCallingInitSessionCCR(d.cfg, session.ID, session.Next(), accountID0.String(), accountID1.String()), accountID0, session)
 }
 
-func (d *DiameterClient) InitVideoCalling(accountID0, accountID1 models.AccountID, sessionID string) error {
-	return d.Send(BuildVideoCallingInitSessionCCR(sessionID, accountID0.String(), accountID1.String()), accountID0)
+func (d *DiameterClient) UpdateVideoCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error) {
+	return d.Send(models.VideoCallingUpdate, BuildVideoCallingUpdateSessionCCR(d.cfg, session.ID, session.Next(), d.usage(session, fixedIMSUsage), accountID0.String(), accountID1.String()), accountID0, session)
 }
 
-func (d *DiameterClient) UpdateVideoCalling(accountID0, accountID1 models.AccountID, sessionID string) error {
-	return d.Send(BuildVideoCallingUpdateSessionCCR(sessionID, accountID0.String(), accountID1.String()), accountID0)
+func (d *DiameterClient) TerminateVideoCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error) {
+	used, cause := d.termination(session, fixedIMSUsage)
+	return d.Send(models.VideoCallingTerminate, BuildVideoCallingTerminateSessionCCR(d.cfg, session.ID, session.Next(), used, cause, accountID0.String(), accountID1.String()), accountID0, session)
 }
 
-func (d *DiameterClient) TerminateVideoCalling(accountID0, accountID1 models.AccountID, sessionID string) error {
-	return d.Send(BuildVideoCallingTerminateSessionCCR(sessionID, accountID0.String(), accountID1.String()), accountID0)
+func (d *DiameterClient) InitVoiceCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error) {
+	return d.Send(models.VoiceCallingInit, BuildVoiceCallingInitSessionCCR(d.cfg, session.ID, session.Next(), accountID0.String(), accountID1.String()), accountID0, session)
 }
 
-func (d *DiameterClient) InitVoiceCalling(accountID0, accountID1 models.AccountID, sessionID string) error {
-	return d.Send(BuildVoiceCallingInitSessionCCR(sessionID, accountID0.String(), accountID1.String()), accountID0)
+func (d *DiameterClient) UpdateVoiceCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error) {
+	return d.Send(models.VoiceCallingUpdate, BuildVoiceCallingUpdateSessionCCR(d.cfg, session.ID, session.Next(), d.usage(session, fixedIMSUsage), accountID0.String(), accountID1.String()), accountID0, session)
 }
 
-func (d *DiameterClient) UpdateVoiceCalling(accountID0, accountID1 models.AccountID, sessionID string) error {
-	return d.Send(BuildVoiceCallingUpdateSessionCCR(sessionID, accountID0.String(), accountID1.String()), accountID0)
+func (d *DiameterClient) TerminateVoiceCalling(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error) {
+	used, cause := d.termination(session, fixedIMSUsage)
+	return d.Send(models.VoiceCallingTerminate, BuildVoiceCallingTerminateSessionCCR(d.cfg, session.ID, session.Next(), used, cause, accountID0.String(), accountID1.String()), accountID0, session)
 }
 
-func (d *DiameterClient) TerminateVoiceCalling(accountID0, accountID1 models.AccountID, sessionID string) error {
-	return d.Send(BuildVoiceCallingTerminateSessionCCR(sessionID, accountID0.String(), accountID1.String()), accountID0)
+func (d *DiameterClient) InitVoiceCalled(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error) {
+	return d.Send(models.VoiceCalledInit, BuildVoiceCalledInitSessionCCR(d.cfg, session.ID, session.Next(), accountID0.String(), accountID1.String()), accountID0, session)
 }
 
-func (d *DiameterClient) InitVoiceCalled(accountID0, accountID1 models.AccountID, sessionID string) error {
-	return d.Send(BuildVoiceCalledInitSessionCCR(sessionID, accountID0.String(), accountID1.String()), accountID0)
+func (d *DiameterClient) UpdateVoiceCalled(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error) {
+	return d.Send(models.VoiceCalledUpdate, BuildVoiceCalledUpdateSessionCCR(d.cfg, session.ID, session.Next(), d.usage(session, fixedIMSUsage), accountID0.String(), accountID1.String()), accountID0, session)
 }
 
-func (d *DiameterClient) UpdateVoiceCalled(accountID0, accountID1 models.AccountID, sessionID string) error {
-	return d.Send(BuildVoiceCalledUpdateSessionCCR(sessionID, accountID0.String(), accountID1.String()), accountID0)
+func (d *DiameterClient) TerminateVoiceCalled(accountID0, accountID1 models.AccountID, session *Session) (*Answer, error) {
+	used, cause := d.termination(session, fixedIMSUsage)
+	return d.Send(models.VoiceCalledTerminate, BuildVoiceCalledTerminateSessionCCR(d.cfg, session.ID, session.Next(), used, cause, accountID0.String(), accountID1.String()), accountID0, session)
 }
 
-func (d *DiameterClient) TerminateVoiceCalled(accountID0, accountID1 models.AccountID, sessionID string) error {
-	return d.Send(BuildVoiceCalledTerminateSessionCCR(sessionID, accountID0.String(), accountID1.String()), accountID0)
+// usage returns what the session's next update reports as used.
+func (d *DiameterClient) usage(session *Session, fallback Usage) Usage {
+	used := d.cfg.Usage.Use(session, fallback)
+	used.Reason = session.reportingReason(used.Reason)
+	return used
+}
+
+// termination returns the usage and Termination-Cause of the session's CCR-T.
+// A session reporting its final units used all of them.
+func (d *DiameterClient) termination(session *Session, fallback Usage) (Usage, uint32) {
+	t := session.Termination()
+	policy := d.cfg.Usage
+	if session.takeFinalReport() {
+		policy = UsagePolicy{Mode: UsageAll}
+	}
+	used := policy.Use(session, fallback)
+	used.Reason = t.ReportingReason
+	return used, t.Cause
+}
+
+// fillResult copies the result code and the granted units of a CCA.
+func fillResult(r *models.Result, a *Answer) {
+	r.ResultCode = a.Code()
+	for _, s := range a.Services {
+		r.GrantedTime += s.Granted.Time
+		r.GrantedTotalOctets += s.Granted.TotalOctets
+		r.GrantedInputOctets += s.Granted.InputOctets
+		r.GrantedOutputOctets += s.Granted.OutputOctets
+	}
+}
+
+// requestNumber reads the CC-Request-Number of a CCR.
+func requestNumber(m *diam.Message) uint32 {
+	a, err := m.FindAVP(avp.CCRequestNumber, 0)
+	if err != nil {
+		return 0
+	}
+	n, _ := a.Data.(datatype.Unsigned32)
+	return uint32(n)
+}
+
+func serviceResultCodes(a *Answer) []uint32 {
+	var codes []uint32
+	for _, s := range a.Services {
+		if s.ResultCode != 0 {
+			codes = append(codes, s.ResultCode)
+		}
+	}
+	return codes
 }
 
-func NewDiameterClient(conn diam.Conn, hopIDs *sync.Map, timeout time.Duration) Client {
+func NewDiameterClient(router *Router, hopIDs, sessions *sync.Map, timeout time.Duration, recorder *report.Recorder, cfg Config, results sink.ResultSink, tracker *monitoring.Tracker, metrics *metrics.Metrics) Client {
 	return &DiameterClient{
-		timeout: timeout,
-		conn:    conn,
-		hopIDs:  hopIDs,
+		timeout:  timeout,
+		router:   router,
+		recorder: recorder,
+		cfg:      cfg,
+		hopIDs:   hopIDs,
+		sessions: sessions,
+		results:  results,
+		tracker:  tracker,
+		metrics:  metrics,
 	}
 }
@@ -1,6 +1,7 @@
 package diameter
 
 import (
+	"crypto/tls"
 	"github.com/MHG14/go-diameter/v4/diam"
 	"github.com/MHG14/go-diameter/v4/diam/avp"
 	"github.com/MHG14/go-diameter/v4/diam/datatype"
@@ -14,42 +15,76 @@ import (
 
 const RetryCount = 100
 
-func NewConnection(hopIDs *sync.Map) (diam.Conn, error) {
-	addr := "192.168.20.244:3868"
-	ssl := false
-	host := "client"
-	realm := "go-diameter"
-	certFile := ""
-	keyFile := ""
-	networkType := "tcp"
+// PeerConfig describes the OCS peers and how we identify ourselves to them.
+type PeerConfig struct {
+	// Address is the only peer when Peers is empty.
+	Address       string
+	Peers         PeerList
+	Routing       Routing
+	Network       string // tcp, sctp or tls
+	OriginHost    string
+	OriginRealm   string
+	HostIPAddress string
+	TLS           TLSConfig
 
+	// A DWR is sent on every connection each WatchdogInterval (0: never)
+	// and must be answered within WatchdogTimeout. WatchdogFailures misses
+	// in a row take the connection down.
+	WatchdogInterval time.Duration
+	WatchdogTimeout  time.Duration
+	WatchdogFailures int
+}
+
+func DefaultPeerConfig() PeerConfig {
+	return PeerConfig{
+		Address:          "192.168.20.244:3868",
+		Network:          "tcp",
+		OriginHost:       "client",
+		OriginRealm:      "go-diameter",
+		HostIPAddress:    "127.0.0.1",
+		Routing:          RoutingRoundRobin,
+		WatchdogInterval: 5 * time.Second,
+		WatchdogTimeout:  2 * time.Second,
+		WatchdogFailures: 3,
+	}
+}
+
+func newClient(peer PeerConfig, hopIDs, sessions *sync.Map) *sm.Client {
 	cfg := &sm.Settings{
-		OriginHost:       datatype.DiameterIdentity(host),
-		OriginRealm:      datatype.DiameterIdentity(realm),
+		OriginHost:       datatype.DiameterIdentity(peer.OriginHost),
+		OriginRealm:      datatype.DiameterIdentity(peer.OriginRealm),
 		VendorID:         0,
 		ProductName:      "go-diameter",
 		OriginStateID:    datatype.Unsigned32(time.Now().Unix()),
 		FirmwareRevision: 1,
-		HostIPAddresses: []datatype.Address{
-			datatype.Address(net.ParseIP("127.0.0.1")),
-		},
+	}
+	if peer.HostIPAddress != "" {
+		cfg.HostIPAddresses = []datatype.Address{
+			datatype.Address(net.ParseIP(peer.HostIPAddress)),
+		}
 	}
 
 	// Create the state machine (it's a diam.ServeMux) and client.
 	mux := sm.New(cfg)
 
 	mux.Handle("CCA", handleResponse(hopIDs))
+	mux.Handle("DWA", handleResponse(hopIDs))
+	mux.Handle("DPA", handleResponse(hopIDs))
+	mux.Handle("RAR", handleRAR(peer, sessions))
+	mux.Handle("ASR", handleASR(peer, sessions))
 
-	cli := &sm.Client{
+	return &sm.Client{
 		Dict:               dict.Default,
 		Handler:            mux,
 		MaxRetransmits:     3,
 		RetransmitInterval: time.Second,
-		EnableWatchdog:     false,
-		WatchdogInterval:   5 * time.Second,
-		WatchdogStream:     0,
-		SupportedVendorID:  nil,
-		AcctApplicationID:  nil,
+		// The pool runs the watchdog itself: sm's would share one DWA
+		// handler between all of the pool's connections.
+		EnableWatchdog:    false,
+		WatchdogInterval:  peer.WatchdogInterval,
+		WatchdogStream:    0,
+		SupportedVendorID: nil,
+		AcctApplicationID: nil,
 		//AcctApplicationID: []*diam.AVP{
 		//	// Advertise that we want support accounting application with id 999
 		//	diam.NewAVP(avp.AcctApplicationID, avp.Mbit, 0, datatype.Unsigned32(4)),
@@ -60,13 +95,26 @@ func NewConnection(hopIDs *sync.Map) (diam.Conn, error) {
 		},
 		VendorSpecificApplicationID: nil,
 	}
+}
+
+// NewConnection dials the peer and completes the CER/CEA handshake,
+// retrying up to RetryCount times. A TLS peer whose certificate fails
+// verification is not retried.
+func NewConnection(cli *sm.Client, peer PeerConfig) (diam.Conn, error) {
+	addr := peer.Address
+	networkType := peer.Network
+
+	tlsConfig, err := peerTLSConfig(peer)
+	if err != nil {
+		return nil, err
+	}
 
 	retry := 0
 Retry:
-	conn, err := dial(cli, addr, certFile, keyFile, ssl, networkType)
+	conn, err := dial(cli, addr, tlsConfig, networkType)
 	if err != nil {
 		retry += 1
-		if retry < RetryCount {
+		if retry < RetryCount && !isPermanent(err) {
 			goto Retry
 		} else {
 			return nil, err
@@ -75,32 +123,33 @@ Retry:
 	return conn, nil
 }
 
-func dial(cli *sm.Client, addr, cert, key string, ssl bool, networkType string) (diam.Conn, error) {
-	if ssl {
-		return cli.DialNetworkTLS(networkType, addr, cert, key, nil)
+// redial makes a single attempt at what NewConnection does; the caller
+// decides when to try again.
+func redial(cli *sm.Client, peer PeerConfig) (diam.Conn, error) {
+	tlsConfig, err := peerTLSConfig(peer)
+	if err != nil {
+		return nil, err
 	}
-	return cli.DialNetwork(networkType, addr)
+	return dial(cli, peer.Address, tlsConfig, peer.Network)
 }
 
-type CCAMessage struct {
-	RequestType datatype.Unsigned32 `avp:"CC-Request-Type"`
+func peerTLSConfig(peer PeerConfig) (*tls.Config, error) {
+	if peer.Network != TransportTLS {
+		return nil, nil
+	}
+	return peer.TLS.clientConfig(peer.Address)
 }
 
-var CCAs []string
-
-func handleCCA() diam.HandlerFunc {
-	return func(c diam.Conn, m *diam.Message) {
-		message := CCAMessage{}
-		err := m.Unmarshal(&message)
+func dial(cli *sm.Client, addr string, tlsConfig *tls.Config, networkType string) (diam.Conn, error) {
+	if tlsConfig != nil {
+		// sm's own TLS dialer does not verify the server certificate.
+		rw, err := tls.Dial(TransportTCP, addr, tlsConfig)
 		if err != nil {
-			log.Println(err)
-			return
-		}
-		//log.Printf("Received CCA from %s ------- %s", c.RemoteAddr(), message.RequestType.String())
-		if message.RequestType == datatype.Unsigned32(3) {
-			CCAs = append(CCAs, message.RequestType.String())
+			return nil, err
 		}
+		return cli.NewConn(rw, addr)
 	}
+	return cli.DialNetwork(networkType, addr)
 }
 
 func handleResponse(hopIds *sync.Map) diam.HandlerFunc {
@@ -112,6 +161,9 @@ func handleResponse(hopIds *sync.Map) diam.HandlerFunc {
 			return
 		}
 		ch := val.(chan *diam.Message)
-		ch <- m
+		select {
+		case ch <- m:
+		default:
+		}
 	}
 }
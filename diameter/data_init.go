@@ -12,31 +12,38 @@ import (
 
 const Abbas = 10415
 
-// Example structures to hold config/user data:
+// Config holds the network and subscriber data the CCR builders fill in.
 type Config struct {
-	MNC                string
-	MCC                string
-	BitrateUL          uint32
-	BitrateDL          uint32
-	PreMiddleFix       string
-	Prefix             string
-	TimeZone           string
-	UserLocationInfo   string
-	DownloadedBytes    uint32
-	UploadedBytes      uint32
-	VideoRequestedTime uint32
+	MNC                 string
+	MCC                 string
+	DataDestinationHost string
+	IMSDestinationHost  string
+	BitrateUL           uint32
+	BitrateDL           uint32
+	PreMiddleFix        string
+	Prefix              string
+	TimeZone            string
+	UserLocationInfo    string
+	VideoRequestedTime  uint32
+	Usage               UsagePolicy
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MNC:                 "020",
+		MCC:                 "418",
+		DataDestinationHost: "CGR-DA.epc.mnc020.mcc418.3gppnetwork.org",
+		IMSDestinationHost:  "hssocs.voiceblue.com",
+	}
 }
 
 // BuildInitSessionCCR builds a CCR message (Credit-Control Request)
 func BuildDataInitSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
 	phoneNumber string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC: "020",
-		MCC: "418",
-	}
-
 	// 1) Create a new CCR message: Command-Code=272 (Credit-Control), App-ID=4 (Gx).
 	m := diam.NewRequest(diam.CreditControl, 4, dict.Default)
 
@@ -80,7 +87,7 @@ func BuildDataInitSessionCCR(
 	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(1)) // 1=INITIAL_REQUEST
 
 	// CC-Request-Number: 415
-	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(seq.RequestNumber))
 
 	// Event-Timestamp: 55
 	m.NewAVP(avp.EventTimestamp, avp.Mbit, 0, datatype.Time(time.Now()))
@@ -12,16 +12,13 @@ import (
 
 // BuildDataTerminateSessionCCR parallels your Node.js `dataTerminate` function in Go.
 func BuildDataTerminateSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
+	used Usage,
+	cause uint32,
 	phoneNumber string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC:             "020",
-		MCC:             "418",
-		UploadedBytes:   53362,
-		DownloadedBytes: 51904,
-	}
-
 	// 1) Create the CCR message: Command-Code=272 (Credit-Control), App-ID=4
 	m := diam.NewRequest(diam.CreditControl, 4, dict.Default)
 
@@ -78,10 +75,7 @@ func BuildDataTerminateSessionCCR(
 		avp.DestinationHost,
 		avp.Mbit,
 		0,
-		datatype.DiameterIdentity(fmt.Sprintf(
-			"CGR-DA.epc.mnc%s.mcc%s.3gppnetwork.org",
-			cfg.MNC, cfg.MCC,
-		)),
+		datatype.DiameterIdentity(cfg.DataDestinationHost),
 	)
 
 	// Auth-Application-Id=4
@@ -103,12 +97,12 @@ func BuildDataTerminateSessionCCR(
 		datatype.Enumerated(3),
 	)
 
-	// CC-Request-Number=1
+	// CC-Request-Number=seq.RequestNumber
 	m.NewAVP(
 		avp.CCRequestNumber,
 		avp.Mbit,
 		0,
-		datatype.Unsigned32(1),
+		datatype.Unsigned32(seq.RequestNumber),
 	)
 
 	// Event-Timestamp => now
@@ -155,8 +149,8 @@ func BuildDataTerminateSessionCCR(
 		},
 	)
 
-	// Termination-Cause=1 (DIAM_USER_REQUESTED?)
-	m.NewAVP(avp.TerminationCause, avp.Mbit, 0, datatype.Enumerated(1))
+	// Termination-Cause=cause (1 => DIAMETER_LOGOUT)
+	m.NewAVP(avp.TerminationCause, avp.Mbit, 0, datatype.Enumerated(cause))
 
 	// Requested-Action=0 => CHECK_BALANCE or DIRECT_DEBIT, depends on spec
 	m.NewAVP(
@@ -184,21 +178,21 @@ func BuildDataTerminateSessionCCR(
 				0,
 				&diam.GroupedAVP{
 					AVP: []*diam.AVP{
-						// CC-Time=0
-						diam.NewAVP(avp.CCTime, avp.Mbit, 0, datatype.Unsigned32(0)),
-						// CC-Input-Octets => DownloadedBytes
-						diam.NewAVP(avp.CCInputOctets, avp.Mbit, 0, datatype.Unsigned64(cfg.UploadedBytes)),
-						// CC-Output-Octets => UploadedBytes
-						diam.NewAVP(avp.CCOutputOctets, avp.Mbit, 0, datatype.Unsigned64(cfg.DownloadedBytes)),
+						// CC-Time=used.Time
+						diam.NewAVP(avp.CCTime, avp.Mbit, 0, datatype.Unsigned32(used.Time)),
+						// CC-Input-Octets => used.InputOctets
+						diam.NewAVP(avp.CCInputOctets, avp.Mbit, 0, datatype.Unsigned64(used.InputOctets)),
+						// CC-Output-Octets => used.OutputOctets
+						diam.NewAVP(avp.CCOutputOctets, avp.Mbit, 0, datatype.Unsigned64(used.OutputOctets)),
 					},
 				},
 			),
-			// Reporting-Reason=2 => e.g., FINAL (?), code from your snippet
+			// Reporting-Reason=used.Reason (2 => FINAL)
 			diam.NewAVP(
 				avp.ReportingReason,
 				avp.Mbit,
 				0,
-				datatype.Enumerated(2),
+				datatype.Enumerated(used.Reason),
 			),
 			// QoSInformation => grouped
 			diam.NewAVP(
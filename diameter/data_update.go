@@ -12,19 +12,15 @@ import (
 
 type IMEIMap map[string]string
 
-// BuildDataUpdateSessionCCR creates a CCR for data usage update,
+// BuildDataUpdateSessionCCR creates a CCR for data usage update reporting used,
 // closely mirroring your Node.js snippet.
 func BuildDataUpdateSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
+	used Usage,
 	phoneNumber string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC:             "020",
-		MCC:             "418",
-		UploadedBytes:   53362,
-		DownloadedBytes: 5190705,
-	}
-
 	// 3GPP Vendor ID
 
 	// Some placeholders for 3GPP-specific AVPs (QoS, RAT type, etc.).
@@ -107,12 +103,12 @@ func BuildDataUpdateSessionCCR(
 		datatype.Enumerated(2),
 	)
 
-	// CC-Request-Number=1
+	// CC-Request-Number=seq.RequestNumber
 	m.NewAVP(
 		avp.CCRequestNumber,
 		avp.Mbit,
 		0,
-		datatype.Unsigned32(1),
+		datatype.Unsigned32(seq.RequestNumber),
 	)
 
 	// Destination-Host
@@ -120,10 +116,7 @@ func BuildDataUpdateSessionCCR(
 		avp.DestinationHost,
 		avp.Mbit,
 		0,
-		datatype.DiameterIdentity(fmt.Sprintf(
-			"CGR-DA.epc.mnc%s.mcc%s.3gppnetwork.org",
-			cfg.MNC, cfg.MCC,
-		)),
+		datatype.DiameterIdentity(cfg.DataDestinationHost),
 	)
 
 	// Event-Timestamp => now
@@ -214,33 +207,33 @@ func BuildDataUpdateSessionCCR(
 				0,
 				&diam.GroupedAVP{
 					AVP: []*diam.AVP{
-						// ReportingReason=3 (e.g., AVP=9992 in custom dict)
+						// ReportingReason=used.Reason (e.g., AVP=9992 in custom dict)
 						diam.NewAVP(
 							avp.ReportingReason,
 							avp.Mbit|avp.Vbit,
 							Abbas,
-							datatype.Enumerated(3),
+							datatype.Enumerated(used.Reason),
 						),
-						// CC-Time=0
+						// CC-Time=used.Time
 						diam.NewAVP(
 							avp.CCTime,
 							avp.Mbit,
 							0,
-							datatype.Unsigned32(0),
+							datatype.Unsigned32(used.Time),
 						),
-						// CC-Input-Octets=downloadedBytes
+						// CC-Input-Octets=used.InputOctets
 						diam.NewAVP(
 							avp.CCInputOctets,
 							avp.Mbit,
 							0,
-							datatype.Unsigned64(cfg.UploadedBytes),
+							datatype.Unsigned64(used.InputOctets),
 						),
-						// CC-Output-Octets=uploadedBytes
+						// CC-Output-Octets=used.OutputOctets
 						diam.NewAVP(
 							avp.CCOutputOctets,
 							avp.Mbit,
 							0,
-							datatype.Unsigned64(cfg.DownloadedBytes),
+							datatype.Unsigned64(used.OutputOctets),
 						),
 					},
 				},
@@ -0,0 +1,71 @@
+package diameter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MHG14/go-diameter/v4/diam"
+	"github.com/MHG14/go-diameter/v4/diam/avp"
+	"github.com/MHG14/go-diameter/v4/diam/datatype"
+	"github.com/MHG14/go-diameter/v4/diam/dict"
+	log "github.com/sirupsen/logrus"
+)
+
+// DisconnectCauseDoNotWantToTalk is the Disconnect-Cause of the DPRs sent
+// on close: the load test is over and the connection is not needed.
+const DisconnectCauseDoNotWantToTalk = 2
+
+// disconnectTimeout is how long Close waits for each DPA.
+const disconnectTimeout = 2 * time.Second
+
+// disconnect sends a DPR on every open connection, waits for the DPAs and
+// closes the connections.
+func (p *Pool) disconnect(conns []diam.Conn) {
+	wg := new(sync.WaitGroup)
+	for i, conn := range conns {
+		if conn == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, conn diam.Conn) {
+			defer wg.Done()
+			defer conn.Close()
+			if err := p.dpr(conn); err != nil {
+				log.Warnf("peer %s connection %d: %v", p.peer.Address, i, err)
+			}
+		}(i, conn)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) dpr(conn diam.Conn) error {
+	m := diam.NewRequest(diam.DisconnectPeer, 0, dict.Default)
+	m.NewAVP(avp.OriginHost, avp.Mbit, 0, datatype.DiameterIdentity(p.peer.OriginHost))
+	m.NewAVP(avp.OriginRealm, avp.Mbit, 0, datatype.DiameterIdentity(p.peer.OriginRealm))
+	m.NewAVP(avp.DisconnectCause, avp.Mbit, 0, datatype.Enumerated(DisconnectCauseDoNotWantToTalk))
+
+	hopID := m.Header.HopByHopID
+	ch := make(chan *diam.Message, 1)
+	p.hopIDs.Store(hopID, ch)
+	defer p.hopIDs.Delete(hopID)
+
+	if _, err := m.WriteTo(conn); err != nil {
+		return fmt.Errorf("unable to send DPR: %w", err)
+	}
+	select {
+	case dpa := <-ch:
+		var answer struct {
+			ResultCode uint32 `avp:"Result-Code"`
+		}
+		if err := dpa.Unmarshal(&answer); err != nil {
+			return err
+		}
+		if !IsSuccess(answer.ResultCode) {
+			return fmt.Errorf("DPA with Result-Code %s", ResultCodeName(answer.ResultCode))
+		}
+		return nil
+	case <-time.After(disconnectTimeout):
+		return fmt.Errorf("no DPA within %v", disconnectTimeout)
+	}
+}
@@ -0,0 +1,285 @@
+package diameter
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MHG14/go-diameter/v4/diam"
+	"github.com/MHG14/go-diameter/v4/diam/sm"
+	log "github.com/sirupsen/logrus"
+	"load-test/report"
+)
+
+// A lost connection is redialed after reconnectInterval, doubling the wait
+// after every failed attempt up to maxReconnectInterval.
+const (
+	reconnectInterval    = time.Second
+	maxReconnectInterval = 30 * time.Second
+)
+
+var ErrNoConnection = errors.New("no open diameter peer connection")
+
+// Pool keeps a fixed number of long-lived, capability-exchanged peer
+// connections open and spreads requests across them round-robin.
+// A connection that goes away, or stops answering its watchdog, is
+// redialed in the background.
+type Pool struct {
+	peer     PeerConfig
+	cli      *sm.Client
+	hopIDs   *sync.Map
+	recorder *report.Recorder
+	conns    []diam.Conn
+	states   []PeerState
+	next     uint32
+
+	mu     sync.RWMutex
+	dialMu sync.Mutex
+	done   chan struct{}
+	once   sync.Once
+}
+
+// newPool dials every connection of the pool. Connections that cannot be
+// opened are redialed in the background; err is the last dial error.
+func newPool(peer PeerConfig, size int, hopIDs, sessions *sync.Map, recorder *report.Recorder) (p *Pool, err error) {
+	if size < 1 {
+		size = 1
+	}
+	if peer.WatchdogFailures < 1 {
+		peer.WatchdogFailures = 1
+	}
+	p = &Pool{
+		peer:     peer,
+		cli:      newClient(peer, hopIDs, sessions),
+		hopIDs:   hopIDs,
+		recorder: recorder,
+		conns:    make([]diam.Conn, size),
+		states:   make([]PeerState, size),
+		done:     make(chan struct{}),
+	}
+	for i := range p.conns {
+		conn, dialErr := p.dial()
+		if dialErr != nil {
+			err = dialErr
+			log.Errorf("peer connection %d to %s err: %v", i, peer.Address, err)
+			p.states[i] = PeerDown
+			go p.reconnect(i)
+			continue
+		}
+		p.conns[i] = conn
+		p.start(i, conn)
+	}
+	return p, err
+}
+
+// Get returns the next okay connection in round-robin order. Suspect and
+// reopened connections are only used when no connection is okay.
+func (p *Pool) Get() (diam.Conn, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	n := len(p.conns)
+	start := int(atomic.AddUint32(&p.next, 1))
+	var fallback diam.Conn
+	for i := 0; i < n; i++ {
+		j := (start + i) % n
+		conn := p.conns[j]
+		if conn == nil {
+			continue
+		}
+		if p.states[j] == PeerOkay {
+			return conn, nil
+		}
+		if fallback == nil {
+			fallback = conn
+		}
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	return nil, ErrNoConnection
+}
+
+// start watches the pool's connection i and runs its watchdog.
+func (p *Pool) start(i int, conn diam.Conn) {
+	closed := conn.(diam.CloseNotifier).CloseNotify()
+	go p.watch(i, conn, closed)
+	if p.peer.WatchdogInterval > 0 {
+		go p.watchdog(i, conn)
+	}
+}
+
+// healthy reports whether the pool has an okay connection.
+func (p *Pool) healthy() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for i, conn := range p.conns {
+		if conn != nil && p.states[i] == PeerOkay {
+			return true
+		}
+	}
+	return false
+}
+
+// open reports whether the pool has any open connection.
+func (p *Pool) open() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, conn := range p.conns {
+		if conn != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// stateCounts counts the pool's connections by state.
+func (p *Pool) stateCounts() map[string]int {
+	counts := make(map[string]int, len(peerStateNames))
+	for _, name := range peerStateNames {
+		counts[name] = 0
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, s := range p.states {
+		counts[s.String()]++
+	}
+	return counts
+}
+
+func (p *Pool) state(i int) PeerState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.states[i]
+}
+
+// setState moves connection i to state, logging and counting the change.
+func (p *Pool) setState(i int, state PeerState) {
+	p.mu.Lock()
+	from := p.states[i]
+	p.states[i] = state
+	p.mu.Unlock()
+	if from == state {
+		return
+	}
+	log.Infof("peer %s connection %d: %s -> %s", p.peer.Address, i, from, state)
+	p.recorder.RecordPeerState(p.peer.Address, from.String(), state.String())
+}
+
+// Close disconnects every connection of the pool with a DPR and stops
+// redialing.
+func (p *Pool) Close() {
+	p.once.Do(func() {
+		close(p.done)
+		p.mu.Lock()
+		conns := make([]diam.Conn, len(p.conns))
+		copy(conns, p.conns)
+		for i := range p.conns {
+			p.conns[i] = nil
+		}
+		p.mu.Unlock()
+		p.disconnect(conns)
+	})
+}
+
+// dial is serialized because the handshake registers its CEA handler on
+// the state machine shared by every connection in the pool.
+func (p *Pool) dial() (diam.Conn, error) {
+	p.dialMu.Lock()
+	defer p.dialMu.Unlock()
+	return NewConnection(p.cli, p.peer)
+}
+
+// redial is dial with a single attempt.
+func (p *Pool) redial() (diam.Conn, error) {
+	p.dialMu.Lock()
+	defer p.dialMu.Unlock()
+	return redial(p.cli, p.peer)
+}
+
+// watch redials the pool's connection i when it closes. go-diameter only
+// starts watching a connection for closed once the next message after
+// CloseNotify arrives on it, so a DWR goes out first to make sure one does.
+// Without a watchdog to judge the peer, a connection that cannot get that
+// DWR answered is taken for lost.
+func (p *Pool) watch(i int, conn diam.Conn, closed <-chan struct{}) {
+	if _, err := p.dwr(conn); err != nil {
+		log.Warnf("peer connection %d to %s: first DWR err: %v", i, p.peer.Address, err)
+		if p.peer.WatchdogInterval <= 0 {
+			p.lost(i, conn)
+			return
+		}
+	}
+	select {
+	case <-closed:
+	case <-p.done:
+		return
+	}
+	p.lost(i, conn)
+}
+
+// lost closes conn, the pool's connection i, and redials it. Only the
+// first of the watch and the watchdog to find conn gone redials it.
+func (p *Pool) lost(i int, conn diam.Conn) {
+	conn.Close()
+	p.mu.Lock()
+	if p.conns[i] != conn {
+		p.mu.Unlock()
+		return
+	}
+	p.conns[i] = nil
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	p.setState(i, PeerDown)
+	log.Warnf("peer connection %d to %s lost, reconnecting", i, p.peer.Address)
+	p.reconnect(i)
+}
+
+// reconnect redials the pool's connection i until it is back or the pool
+// is closed.
+func (p *Pool) reconnect(i int) {
+	wait := reconnectInterval
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(wait):
+		}
+		newConn, err := p.redial()
+		if err != nil {
+			wait = nextReconnectWait(wait)
+			log.Errorf("reconnect peer connection %d err: %v, next attempt in %v", i, err, wait)
+			continue
+		}
+		p.mu.Lock()
+		select {
+		case <-p.done:
+			p.mu.Unlock()
+			newConn.Close()
+			return
+		default:
+		}
+		p.conns[i] = newConn
+		p.mu.Unlock()
+		log.Infof("peer connection %d reestablished", i)
+		if p.peer.WatchdogInterval > 0 {
+			p.setState(i, PeerReopen)
+		} else {
+			p.setState(i, PeerOkay)
+		}
+		p.start(i, newConn)
+		return
+	}
+}
+
+// nextReconnectWait is the wait before the attempt after one that waited
+// wait and failed.
+func nextReconnectWait(wait time.Duration) time.Duration {
+	return min(2*wait, maxReconnectInterval)
+}
@@ -0,0 +1,265 @@
+package diameter
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MHG14/go-diameter/v4/diam"
+	"load-test/ocs"
+	"load-test/report"
+)
+
+// startOCS serves the fake OCS with settings over TCP on a loopback port.
+func startOCS(t *testing.T, settings ocs.Settings) (*ocs.Server, string) {
+	t.Helper()
+	settings.Network = TransportTCP
+	settings.Addr = "127.0.0.1:0"
+	server, err := ocs.New(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := server.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(server.Close)
+	return server, addr.String()
+}
+
+// proxy forwards loopback connections to a peer, and can cut them or
+// silence the peer to stand in for a network that fails.
+type proxy struct {
+	ln     net.Listener
+	target string
+	muted  atomic.Bool
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startProxy(t *testing.T, target string) *proxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	px := &proxy{ln: ln, target: target}
+	go px.serve()
+	t.Cleanup(px.close)
+	return px
+}
+
+func (px *proxy) addr() string {
+	return px.ln.Addr().String()
+}
+
+func (px *proxy) serve() {
+	for {
+		client, err := px.ln.Accept()
+		if err != nil {
+			return
+		}
+		peer, err := net.Dial("tcp", px.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+		px.mu.Lock()
+		px.conns = append(px.conns, client, peer)
+		px.mu.Unlock()
+		go px.copy(peer, client, false)
+		go px.copy(client, peer, true)
+	}
+}
+
+// copy forwards src to dst; bytes from the peer are dropped while muted.
+func (px *proxy) copy(dst, src net.Conn, fromPeer bool) {
+	defer dst.Close()
+	buf := make([]byte, 4096)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 && !(fromPeer && px.muted.Load()) {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				src.Close()
+			}
+			return
+		}
+	}
+}
+
+// cut closes every connection through the proxy.
+func (px *proxy) cut() {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	for _, conn := range px.conns {
+		conn.Close()
+	}
+	px.conns = nil
+}
+
+func (px *proxy) close() {
+	px.ln.Close()
+	px.cut()
+}
+
+func tcpPeer(addr string) PeerConfig {
+	peer := DefaultPeerConfig()
+	peer.Address = addr
+	peer.WatchdogInterval = 0
+	return peer
+}
+
+func testPool(t *testing.T, peer PeerConfig, size int) (*Pool, error) {
+	t.Helper()
+	p, err := newPool(peer, size, new(sync.Map), new(sync.Map), report.NewRecorder())
+	t.Cleanup(p.Close)
+	return p, err
+}
+
+// waitFor polls cond until it holds or timeout passes.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not within %v", what, timeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoolGetRoundRobin(t *testing.T) {
+	_, addr := startOCS(t, ocs.DefaultSettings())
+	p, err := testPool(t, tcpPeer(addr), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []diam.Conn
+	for i := 0; i < 6; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		got = append(got, conn)
+	}
+	if got[0] == got[1] || got[1] == got[2] || got[0] == got[2] {
+		t.Errorf("Get returned a connection twice in a row of three")
+	}
+	for i := 0; i < 3; i++ {
+		if got[i] != got[i+3] {
+			t.Errorf("Get %d and %d returned different connections, want round-robin order", i, i+3)
+		}
+	}
+}
+
+func TestPoolGetPrefersOkay(t *testing.T) {
+	_, addr := startOCS(t, ocs.DefaultSettings())
+	p, err := testPool(t, tcpPeer(addr), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.setState(0, PeerSuspect)
+	p.setState(2, PeerReopen)
+	for i := 0; i < 6; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if conn != p.conns[1] {
+			t.Fatalf("Get returned a %s connection, want the okay one", p.states[indexOf(p.conns, conn)])
+		}
+	}
+
+	p.setState(1, PeerSuspect)
+	if conn, err := p.Get(); err != nil || conn == nil {
+		t.Errorf("Get without okay connections = %v, %v, want a fallback", conn, err)
+	}
+}
+
+func indexOf(conns []diam.Conn, conn diam.Conn) int {
+	for i, c := range conns {
+		if c == conn {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestPoolReconnect(t *testing.T) {
+	_, addr := startOCS(t, ocs.DefaultSettings())
+	px := startProxy(t, addr)
+	p, err := testPool(t, tcpPeer(px.addr()), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := p.conns[0]
+	px.cut()
+
+	waitFor(t, DefaultPeerConfig().WatchdogTimeout+time.Second, "lost connection marked down", func() bool { return p.state(0) == PeerDown })
+	if _, err := p.Get(); err != ErrNoConnection {
+		t.Errorf("Get while the connection is down = %v, want %v", err, ErrNoConnection)
+	}
+	waitFor(t, reconnectInterval+2*time.Second, "connection redialed", func() bool {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.conns[0] != nil && p.conns[0] != first && p.states[0] == PeerOkay
+	})
+}
+
+func TestPoolLostOnce(t *testing.T) {
+	_, addr := startOCS(t, ocs.DefaultSettings())
+	p, err := testPool(t, tcpPeer(addr), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := p.recorder
+	conn := p.conns[0]
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.lost(0, conn)
+		}()
+	}
+	wg.Wait()
+	// The watch of conn sees it closed too.
+	waitFor(t, reconnectInterval+2*time.Second, "connection redialed", func() bool {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.conns[0] != nil && p.conns[0] != conn && p.states[0] == PeerOkay
+	})
+	time.Sleep(100 * time.Millisecond)
+	// Only the first of the calls redials.
+	peers := recorder.Summary().Peers
+	if len(peers) != 1 || peers[0].Transitions["okay->down"] != 1 || peers[0].Transitions["down->okay"] != 1 {
+		t.Errorf("peer summary %+v, want one okay->down and one down->okay", peers)
+	}
+}
+
+func TestPoolRedialsUnreachablePeer(t *testing.T) {
+	server, addr := startOCS(t, ocs.DefaultSettings())
+	server.Close()
+	peer := tcpPeer(addr)
+	p, err := testPool(t, peer, 1)
+	if err == nil {
+		t.Fatal("newPool to a closed port succeeded, want the dial error")
+	}
+	if p.open() || p.state(0) != PeerDown {
+		t.Errorf("pool open %v in state %s, want no connection and down", p.open(), p.state(0))
+	}
+	if _, err := p.Get(); err != ErrNoConnection {
+		t.Errorf("Get = %v, want %v", err, ErrNoConnection)
+	}
+}
@@ -0,0 +1,64 @@
+package diameter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MHG14/go-diameter/v4/diam"
+	"github.com/MHG14/go-diameter/v4/diam/avp"
+	"github.com/MHG14/go-diameter/v4/diam/datatype"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReAuth is a Re-Auth-Request the OCS sent for Session at At.
+type ReAuth struct {
+	Session *Session
+	At      time.Time
+}
+
+type rar struct {
+	SessionID string `avp:"Session-Id"`
+}
+
+// handleRAR answers a Re-Auth-Request (RFC 4006 5.5) and hands it to the
+// flow that owns the session, which reauthorizes with a CCR-U. sessions
+// maps the wire Session-Id of every open session to its *Session.
+func handleRAR(peer PeerConfig, sessions *sync.Map) diam.HandlerFunc {
+	return func(c diam.Conn, m *diam.Message) {
+		at := time.Now()
+		req := rar{}
+		if err := m.Unmarshal(&req); err != nil {
+			log.Errorf("unable to decode RAR: %v", err)
+			return
+		}
+
+		code := uint32(ResultSuccess)
+		val, ok := sessions.Load(req.SessionID)
+		if !ok {
+			log.Warnf("Received RAR for unknown session %s", req.SessionID)
+			code = ResultUnknownSessionID
+		}
+
+		a := m.Answer(code)
+		a.NewAVP(avp.SessionID, avp.Mbit, 0, datatype.UTF8String(req.SessionID))
+		a.NewAVP(avp.OriginHost, avp.Mbit, 0, datatype.DiameterIdentity(peer.OriginHost))
+		a.NewAVP(avp.OriginRealm, avp.Mbit, 0, datatype.DiameterIdentity(peer.OriginRealm))
+		if _, err := a.WriteTo(c); err != nil {
+			log.Errorf("unable to write RAA: %v", err)
+		}
+
+		if ok {
+			val.(*Session).reAuth(at)
+		}
+	}
+}
+
+// wireSessionID returns the Session-Id AVP of m.
+func wireSessionID(m *diam.Message) string {
+	a, err := m.FindAVP(avp.SessionID, 0)
+	if err != nil {
+		return ""
+	}
+	id, _ := a.Data.(datatype.UTF8String)
+	return string(id)
+}
@@ -0,0 +1,268 @@
+package diameter
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/MHG14/go-diameter/v4/diam"
+	log "github.com/sirupsen/logrus"
+	"load-test/report"
+)
+
+// PeerAddress is one OCS node. Weight shares traffic among the peers of
+// its tier; Backup peers only take traffic when no primary peer is up.
+type PeerAddress struct {
+	Address string
+	Weight  int
+	Backup  bool
+}
+
+// PeerList is a list of peers written as "host:port=weight,host:port";
+// the weight defaults to 1.
+type PeerList []PeerAddress
+
+func ParsePeerList(spec string) (PeerList, error) {
+	var peers PeerList
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		address, weight, hasWeight := strings.Cut(entry, "=")
+		p := PeerAddress{Address: address, Weight: 1}
+		if hasWeight {
+			w, err := strconv.Atoi(weight)
+			if err != nil || w < 1 {
+				return nil, fmt.Errorf("invalid weight %q for peer %s", weight, address)
+			}
+			p.Weight = w
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+func (l PeerList) String() string {
+	entries := make([]string, len(l))
+	for i, p := range l {
+		entries[i] = fmt.Sprintf("%s=%d", p.Address, p.Weight)
+	}
+	return strings.Join(entries, ",")
+}
+
+// UnmarshalText lets the list be set from config files and flags.
+func (l *PeerList) UnmarshalText(text []byte) error {
+	v, err := ParsePeerList(string(text))
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+func (l PeerList) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// Routing picks the peer of each request among the peers that are up.
+type Routing string
+
+const (
+	// RoutingRoundRobin takes the peers in turn, ignoring weights.
+	RoutingRoundRobin Routing = "round-robin"
+	// RoutingWeighted picks a peer at random in proportion to its weight.
+	RoutingWeighted Routing = "weighted"
+	// RoutingSticky keeps every request of a Session-Id on one peer for
+	// as long as it is up, spreading sessions by weight.
+	RoutingSticky Routing = "sticky"
+)
+
+func (r *Routing) UnmarshalText(text []byte) error {
+	switch v := Routing(text); v {
+	case RoutingRoundRobin, RoutingWeighted, RoutingSticky:
+		*r = v
+		return nil
+	case "":
+		*r = RoutingRoundRobin
+		return nil
+	}
+	return fmt.Errorf("unknown routing %q: want round-robin, weighted or sticky", text)
+}
+
+func (r Routing) MarshalText() ([]byte, error) {
+	return []byte(r), nil
+}
+
+type routedPool struct {
+	*Pool
+	weight int
+	backup bool
+}
+
+// Router spreads requests over a pool of connections per OCS peer and fails
+// over to the backup peers while no primary peer is up.
+type Router struct {
+	pools   []*routedPool
+	routing Routing
+	next    uint32
+
+	failedOver atomic.Bool
+}
+
+// NewRouter opens size connections to every peer of peer.Peers, or to
+// peer.Address when there are none.
+func NewRouter(peer PeerConfig, size int, hopIDs, sessions *sync.Map, recorder *report.Recorder) (*Router, error) {
+	peers := peer.Peers
+	if len(peers) == 0 {
+		peers = []PeerAddress{{Address: peer.Address, Weight: 1}}
+	}
+	r := &Router{routing: peer.Routing}
+	if r.routing == "" {
+		r.routing = RoutingRoundRobin
+	}
+	var lastErr error
+	open := false
+	for _, p := range peers {
+		cfg := peer
+		cfg.Address = p.Address
+		// A peer that is down at start is redialed like one lost later.
+		pool, err := newPool(cfg, size, hopIDs, sessions, recorder)
+		if err != nil {
+			lastErr = fmt.Errorf("peer %s: %w", p.Address, err)
+		}
+		open = open || pool.open()
+		weight := p.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		r.pools = append(r.pools, &routedPool{Pool: pool, weight: weight, backup: p.Backup})
+	}
+	if !open {
+		r.Close()
+		return nil, lastErr
+	}
+	return r, nil
+}
+
+// Close disconnects from every peer at once.
+func (r *Router) Close() {
+	wg := new(sync.WaitGroup)
+	wg.Add(len(r.pools))
+	for _, p := range r.pools {
+		go func(p *routedPool) {
+			defer wg.Done()
+			p.Close()
+		}(p)
+	}
+	wg.Wait()
+}
+
+// ConnectionStates counts the connections of every peer by state.
+func (r *Router) ConnectionStates() map[string]map[string]int {
+	states := make(map[string]map[string]int, len(r.pools))
+	for _, p := range r.pools {
+		states[p.peer.Address] = p.stateCounts()
+	}
+	return states
+}
+
+// Get returns a connection for a request of the session and the address of
+// the peer it goes to. Peers with an okay connection are preferred,
+// primaries before backups; a peer whose connections are all suspect is
+// only used when no other peer is left.
+func (r *Router) Get(sessionID string) (diam.Conn, string, error) {
+	tiers := []func(*routedPool) bool{
+		func(p *routedPool) bool { return !p.backup && p.healthy() },
+		func(p *routedPool) bool { return p.backup && p.healthy() },
+		func(p *routedPool) bool { return !p.backup && p.open() },
+		func(p *routedPool) bool { return p.backup && p.open() },
+	}
+	for i, up := range tiers {
+		var candidates []*routedPool
+		for _, p := range r.pools {
+			if up(p) {
+				candidates = append(candidates, p)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		r.noteTier(i)
+		p := r.pick(candidates, sessionID)
+		conn, err := p.Get()
+		return conn, p.peer.Address, err
+	}
+	return nil, "", ErrNoConnection
+}
+
+// noteTier logs when traffic moves to or away from the backup peers.
+func (r *Router) noteTier(tier int) {
+	backup := tier == 1 || tier == 3
+	if r.failedOver.Swap(backup) == backup {
+		return
+	}
+	if backup {
+		log.Warnf("no primary peer is up, failing over to the backup peers")
+	} else {
+		log.Infof("primary peers are back up")
+	}
+}
+
+func (r *Router) pick(candidates []*routedPool, sessionID string) *routedPool {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	switch r.routing {
+	case RoutingWeighted:
+		total := 0
+		for _, p := range candidates {
+			total += p.weight
+		}
+		n := rand.Intn(total)
+		for _, p := range candidates {
+			if n < p.weight {
+				return p
+			}
+			n -= p.weight
+		}
+	case RoutingSticky:
+		return rendezvous(candidates, sessionID)
+	}
+	return candidates[int(atomic.AddUint32(&r.next, 1))%len(candidates)]
+}
+
+// rendezvous picks the candidate with the highest weighted score for key.
+// A session only moves when its peer goes down.
+func rendezvous(candidates []*routedPool, key string) *routedPool {
+	var best *routedPool
+	bestScore := math.Inf(-1)
+	for _, p := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte(p.peer.Address))
+		// u is uniform in (0, 1); -w/ln(u) weights the draw.
+		u := (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+		score := -float64(p.weight) / math.Log(u)
+		if score > bestScore {
+			best, bestScore = p, score
+		}
+	}
+	return best
+}
+
+// mix64 is the splitmix64 finalizer. FNV barely carries the last bytes
+// into the high bits, which u is made of.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
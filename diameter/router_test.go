@@ -0,0 +1,194 @@
+package diameter
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"load-test/ocs"
+	"load-test/report"
+)
+
+func TestParsePeerList(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+		err  bool
+	}{
+		{spec: "10.0.0.1:3868", want: "10.0.0.1:3868=1"},
+		{spec: "10.0.0.1:3868=3, 10.0.0.2:3868", want: "10.0.0.1:3868=3,10.0.0.2:3868=1"},
+		{spec: "10.0.0.1:3868,,", want: "10.0.0.1:3868=1"},
+		{spec: "10.0.0.1:3868=0", err: true},
+		{spec: "10.0.0.1:3868=-2", err: true},
+		{spec: "10.0.0.1:3868=heavy", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParsePeerList(tt.spec)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ParsePeerList(%q) = %v, want an error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil || got.String() != tt.want {
+				t.Errorf("ParsePeerList(%q) = %q, %v, want %q", tt.spec, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// testRouter routes over peers, each an address and weight, in turn
+// listed in peer.Peers.
+func testRouter(t *testing.T, peer PeerConfig, peers PeerList) *Router {
+	t.Helper()
+	peer.Peers = peers
+	r, err := NewRouter(peer, 1, new(sync.Map), new(sync.Map), report.NewRecorder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+// twoPeers starts two fake OCS peers.
+func twoPeers(t *testing.T) (a, b string) {
+	_, a = startOCS(t, ocs.DefaultSettings())
+	_, b = startOCS(t, ocs.DefaultSettings())
+	return a, b
+}
+
+func routeOf(t *testing.T, r *Router, sessionID string) string {
+	t.Helper()
+	_, addr, err := r.Get(sessionID)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", sessionID, err)
+	}
+	return addr
+}
+
+func TestRouterWeighted(t *testing.T) {
+	a, b := twoPeers(t)
+	peer := tcpPeer(a)
+	peer.Routing = RoutingWeighted
+	r := testRouter(t, peer, PeerList{{Address: a, Weight: 3}, {Address: b, Weight: 1}})
+
+	const requests = 8000
+	counts := map[string]int{}
+	for i := 0; i < requests; i++ {
+		counts[routeOf(t, r, fmt.Sprintf("weighted;%d", i))]++
+	}
+	if share := float64(counts[a]) / requests; share < 0.72 || share > 0.78 {
+		t.Errorf("peer of weight 3 of 4 took %.1f%% of the traffic, want about 75%%", share*100)
+	}
+}
+
+func TestRouterRoundRobin(t *testing.T) {
+	a, b := twoPeers(t)
+	r := testRouter(t, tcpPeer(a), PeerList{{Address: a, Weight: 3}, {Address: b, Weight: 1}})
+	first := routeOf(t, r, "rr")
+	for i := 1; i < 10; i++ {
+		addr := routeOf(t, r, "rr")
+		if (addr == first) != (i%2 == 0) {
+			t.Fatalf("request %d went to %s, want the peers in turn regardless of weight", i, addr)
+		}
+	}
+}
+
+func TestRouterSticky(t *testing.T) {
+	a, b := twoPeers(t)
+	pxA, pxB := startProxy(t, a), startProxy(t, b)
+	peer := tcpPeer(pxA.addr())
+	peer.Routing = RoutingSticky
+	r := testRouter(t, peer, PeerList{{Address: pxA.addr(), Weight: 1}, {Address: pxB.addr(), Weight: 1}})
+
+	const sessions = 1000
+	route := make(map[string]string, sessions)
+	counts := map[string]int{}
+	for i := 0; i < sessions; i++ {
+		id := fmt.Sprintf("sticky;%d", i)
+		route[id] = routeOf(t, r, id)
+		counts[route[id]]++
+	}
+	if share := float64(counts[pxA.addr()]) / sessions; share < 0.45 || share > 0.55 {
+		t.Errorf("equal peers took %.1f%% and %.1f%% of the sessions, want about half each",
+			share*100, 100-share*100)
+	}
+	for id, addr := range route {
+		if got := routeOf(t, r, id); got != addr {
+			t.Fatalf("session %s went to %s, then %s, want it to stay", id, addr, got)
+		}
+	}
+
+	// With b down its sessions move to a and the others stay put. The
+	// close is noticed once the first DWR of the connection was answered.
+	time.Sleep(100 * time.Millisecond)
+	pxB.close()
+	pool := r.pools[1]
+	waitFor(t, time.Second, "peer down", func() bool { return !pool.open() })
+	for id := range route {
+		if got := routeOf(t, r, id); got != pxA.addr() {
+			t.Fatalf("session %s went to %s with the other peer down, want %s", id, got, pxA.addr())
+		}
+	}
+}
+
+func TestRendezvousStability(t *testing.T) {
+	pools := func(addrs ...string) []*routedPool {
+		var l []*routedPool
+		for _, addr := range addrs {
+			l = append(l, &routedPool{Pool: &Pool{peer: PeerConfig{Address: addr}}, weight: 1})
+		}
+		return l
+	}
+	three := pools("10.0.0.1:3868", "10.0.0.2:3868", "10.0.0.3:3868")
+	two := three[:2]
+
+	moved := 0
+	const sessions = 3000
+	for i := 0; i < sessions; i++ {
+		id := fmt.Sprintf("rendezvous;%d", i)
+		before, after := rendezvous(two, id), rendezvous(three, id)
+		if after != before {
+			if after != three[2] {
+				t.Fatalf("session %s moved from %s to %s when a third peer was added, want only moves to the new peer",
+					id, before.peer.Address, after.peer.Address)
+			}
+			moved++
+		}
+	}
+	// The new peer takes about a third of the sessions.
+	if share := float64(moved) / sessions; share < 0.30 || share > 0.37 {
+		t.Errorf("%.1f%% of the sessions moved to the third peer, want about a third", share*100)
+	}
+}
+
+func TestRouterFailover(t *testing.T) {
+	primary, backup := twoPeers(t)
+	px := startProxy(t, primary)
+	r := testRouter(t, watchdogPeer(px.addr(), 2), PeerList{{Address: px.addr(), Weight: 1}, {Address: backup, Weight: 1, Backup: true}})
+
+	for i := 0; i < 4; i++ {
+		if addr := routeOf(t, r, "failover"); addr != px.addr() {
+			t.Fatalf("request went to %s with the primary up, want %s", addr, px.addr())
+		}
+	}
+
+	// The primary stops answering its watchdogs and is taken down.
+	px.muted.Store(true)
+	pool := r.pools[0]
+	waitFor(t, time.Second, "primary down", func() bool { return pool.state(0) == PeerDown })
+	for i := 0; i < 4; i++ {
+		if addr := routeOf(t, r, "failover"); addr != backup {
+			t.Fatalf("request went to %s with the primary down, want the backup %s", addr, backup)
+		}
+	}
+
+	// Once it answers again and is redialed, traffic goes back to it.
+	px.muted.Store(false)
+	waitFor(t, reconnectInterval+time.Second, "primary okay", func() bool { return pool.state(0) == PeerOkay })
+	if addr := routeOf(t, r, "failover"); addr != px.addr() {
+		t.Errorf("request went to %s with the primary back, want %s", addr, px.addr())
+	}
+}
@@ -0,0 +1,191 @@
+package diameter
+
+import (
+	"sync"
+	"time"
+)
+
+// Termination-Cause (RFC 6733 8.15) and 3GPP Reporting-Reason (TS 32.299)
+// values sent when a session's units run out or it ends.
+const (
+	TerminationCauseLogout             uint32 = 1
+	TerminationCauseServiceNotProvided uint32 = 2
+	TerminationCauseAdministrative     uint32 = 4
+	TerminationCauseAuthExpired        uint32 = 6
+
+	ReportingReasonFinal          uint32 = 2
+	ReportingReasonQuotaExhausted uint32 = 3
+)
+
+// Termination says why a session's CCR-T is sent.
+type Termination struct {
+	Cause           uint32
+	ReportingReason uint32
+}
+
+var (
+	NormalTermination = Termination{TerminationCauseLogout, ReportingReasonFinal}
+	// FinalUnitTermination ends a session whose final units are used up.
+	FinalUnitTermination = Termination{TerminationCauseAuthExpired, ReportingReasonFinal}
+	// CreditLimitTermination ends a session the OCS refused with 4012.
+	CreditLimitTermination = Termination{TerminationCauseServiceNotProvided, ReportingReasonQuotaExhausted}
+	// AbortTermination ends a session the OCS aborted with an ASR.
+	AbortTermination = Termination{TerminationCauseAdministrative, ReportingReasonFinal}
+	// ShutdownTermination ends the sessions still open when a run stops.
+	ShutdownTermination = Termination{TerminationCauseAdministrative, ReportingReasonFinal}
+)
+
+// Sequence holds the numbers that identify one request within its session.
+type Sequence struct {
+	RequestNumber uint32
+	RecordNumber  uint32
+}
+
+// Session is the client-side state of one credit-control session.
+// CC-Request-Number is 0 on the initial request and grows by one with
+// every request after it; Accounting-Record-Number, sent by the IMS flows,
+// follows the same rule. It is safe for concurrent use.
+type Session struct {
+	ID string
+
+	mu          sync.Mutex
+	next        Sequence
+	granted     GrantedServiceUnit
+	hasGrant    bool
+	finalReport bool
+	termination Termination
+	reauths     chan<- ReAuth
+	aborted     bool
+	aborts      chan<- *Session
+}
+
+func NewSession(id string) *Session {
+	return &Session{ID: id, termination: NormalTermination}
+}
+
+// ReportFinal makes the next update or CCR-T report its usage as the final
+// units.
+func (s *Session) ReportFinal() {
+	s.mu.Lock()
+	s.finalReport = true
+	s.mu.Unlock()
+}
+
+// takeFinalReport reports whether the next request reports the final units,
+// and clears it.
+func (s *Session) takeFinalReport() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	final := s.finalReport
+	s.finalReport = false
+	return final
+}
+
+// reportingReason returns the Reporting-Reason of the next update.
+func (s *Session) reportingReason(def uint32) uint32 {
+	if s.takeFinalReport() {
+		return ReportingReasonFinal
+	}
+	return def
+}
+
+func (s *Session) SetTermination(t Termination) {
+	s.mu.Lock()
+	s.termination = t
+	s.mu.Unlock()
+}
+
+func (s *Session) Termination() Termination {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.termination
+}
+
+// NotifyReAuth makes RARs for the session arrive on ch. RARs that find ch
+// full are dropped; one pending reauthorization is as good as several.
+func (s *Session) NotifyReAuth(ch chan<- ReAuth) {
+	s.mu.Lock()
+	s.reauths = ch
+	s.mu.Unlock()
+}
+
+func (s *Session) reAuth(at time.Time) {
+	s.mu.Lock()
+	ch := s.reauths
+	s.mu.Unlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- ReAuth{Session: s, At: at}:
+	default:
+	}
+}
+
+// NotifyAbort makes the session arrive on ch when the OCS aborts it.
+func (s *Session) NotifyAbort(ch chan<- *Session) {
+	s.mu.Lock()
+	s.aborts = ch
+	s.mu.Unlock()
+}
+
+// Aborted reports whether the OCS aborted the session with an ASR.
+func (s *Session) Aborted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aborted
+}
+
+func (s *Session) abort() {
+	s.mu.Lock()
+	s.aborted = true
+	ch := s.aborts
+	s.mu.Unlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- s:
+	default:
+	}
+}
+
+// Next reserves the numbers for the session's next request.
+func (s *Session) Next() Sequence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := s.next
+	s.next.RequestNumber++
+	s.next.RecordNumber++
+	return seq
+}
+
+// Grant records the units granted by a CCA, summed over its MSCCs. An
+// answer without Granted-Service-Unit leaves the previous grant in place.
+func (s *Session) Grant(a *Answer) {
+	var g GrantedServiceUnit
+	found := false
+	for _, sc := range a.Services {
+		if sc.Granted == (GrantedServiceUnit{}) {
+			continue
+		}
+		found = true
+		g.Time += sc.Granted.Time
+		g.TotalOctets += sc.Granted.TotalOctets
+		g.InputOctets += sc.Granted.InputOctets
+		g.OutputOctets += sc.Granted.OutputOctets
+	}
+	if !found {
+		return
+	}
+	s.mu.Lock()
+	s.granted, s.hasGrant = g, true
+	s.mu.Unlock()
+}
+
+// Granted returns the last grant and whether there was one.
+func (s *Session) Granted() (GrantedServiceUnit, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.granted, s.hasGrant
+}
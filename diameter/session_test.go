@@ -0,0 +1,122 @@
+package diameter
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"load-test/models"
+	"load-test/ocs"
+	"load-test/report"
+)
+
+func TestSessionNextConcurrent(t *testing.T) {
+	const goroutines, each = 50, 20
+	s := NewSession("next-test")
+	seqs := make(chan Sequence, goroutines*each)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				seqs <- s.Next()
+			}
+		}()
+	}
+	wg.Wait()
+	close(seqs)
+
+	seen := make(map[uint32]bool)
+	for seq := range seqs {
+		if seq.RecordNumber != seq.RequestNumber {
+			t.Errorf("Next = %+v, want Accounting-Record-Number to follow CC-Request-Number", seq)
+		}
+		if seen[seq.RequestNumber] {
+			t.Fatalf("CC-Request-Number %d reserved twice", seq.RequestNumber)
+		}
+		seen[seq.RequestNumber] = true
+	}
+	for n := uint32(0); n < goroutines*each; n++ {
+		if !seen[n] {
+			t.Fatalf("CC-Request-Number %d never reserved", n)
+		}
+	}
+	if next := s.Next(); next.RequestNumber != goroutines*each {
+		t.Errorf("Next after %d = %+v, want %d", goroutines*each, next, goroutines*each)
+	}
+}
+
+// TestSessionNextWithReAuth sends a session's updates while a RAR asks for
+// one more from another goroutine: every number goes out exactly once.
+func TestSessionNextWithReAuth(t *testing.T) {
+	const updates = 20
+	settings := ocs.DefaultSettings()
+	settings.KeepRequests = true
+	settings.ReAuthAfter = 20 * time.Millisecond
+	server, addr := startOCS(t, settings)
+
+	hopIDs, sessions := new(sync.Map), new(sync.Map)
+	recorder := report.NewRecorder()
+	router, err := NewRouter(tcpPeer(addr), 2, hopIDs, sessions, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+	client := NewDiameterClient(router, hopIDs, sessions, 5*time.Second, recorder, DefaultConfig(), nil, nil, nil)
+
+	account := models.NewAccountID(1)
+	s := NewSession("reauth-next-test")
+	reauths := make(chan ReAuth, 1)
+	s.NotifyReAuth(reauths)
+	if _, err := client.InitData(account, s); err != nil {
+		t.Fatal(err)
+	}
+
+	reauthorized := make(chan error, 1)
+	go func() {
+		<-reauths
+		_, err := client.UpdateData(account, s)
+		reauthorized <- err
+	}()
+	for i := 0; i < updates; i++ {
+		if _, err := client.UpdateData(account, s); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	select {
+	case err := <-reauthorized:
+		if err != nil {
+			t.Fatalf("CCR-U for the RAR: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no RAR arrived")
+	}
+	if _, err := client.TerminateData(account, s); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := server.Requests()
+	const want = 1 + updates + 1 + 1
+	if len(requests) != want {
+		t.Fatalf("OCS received %d CCRs, want %d", len(requests), want)
+	}
+	numbers := make([]int, len(requests))
+	for i, r := range requests {
+		numbers[i] = int(r.Number)
+	}
+	sort.Ints(numbers)
+	for i, n := range numbers {
+		if n != i {
+			t.Fatalf("CC-Request-Numbers %v, want each of 0..%d once", numbers, want-1)
+		}
+	}
+	if last := requests[len(requests)-1]; last.Number != want-1 {
+		t.Errorf("CCR-T has CC-Request-Number %d, want %d", last.Number, want-1)
+	}
+	if stats := server.Stats(); stats.ReAuthAnswers != 1 {
+		t.Errorf("OCS got %d RAAs, want 1", stats.ReAuthAnswers)
+	}
+}
@@ -0,0 +1,86 @@
+package diameter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Transports a PeerConfig Network can name. TLS runs over TCP.
+const (
+	TransportTCP  = "tcp"
+	TransportSCTP = "sctp"
+	TransportTLS  = "tls"
+)
+
+// ParseTransport returns the transport named s; "tls-over-tcp" is another
+// name for tls.
+func ParseTransport(s string) (string, error) {
+	switch s {
+	case TransportTCP, TransportSCTP, TransportTLS:
+		return s, nil
+	case "tls-over-tcp":
+		return TransportTLS, nil
+	}
+	return "", fmt.Errorf("unknown transport %q: want tcp, sctp or tls", s)
+}
+
+// TLSConfig secures the peer connections when the transport is tls.
+type TLSConfig struct {
+	// CertFile and KeyFile hold the client certificate, if the peer asks
+	// for one.
+	CertFile string
+	KeyFile  string
+	// CAFile holds the CAs the peer's certificate is verified against;
+	// the system roots are used when it is empty.
+	CAFile string
+	// ServerName is checked against the peer's certificate; it defaults to
+	// the host of the peer address.
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// clientConfig builds the tls.Config for dialing addr.
+func (c TLSConfig) clientConfig(addr string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid peer address %s", addr)
+		}
+		config.ServerName = host
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load TLS client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read TLS CA file")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS CA file %s", c.CAFile)
+		}
+	}
+	return config, nil
+}
+
+// isPermanent reports whether a dial error will not go away by retrying.
+func isPermanent(err error) bool {
+	var verify *tls.CertificateVerificationError
+	var network net.UnknownNetworkError
+	return errors.As(err, &verify) || errors.As(err, &network)
+}
@@ -0,0 +1,203 @@
+package diameter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"load-test/models"
+	"load-test/ocs"
+	"load-test/report"
+)
+
+// writeCerts writes a CA and a server certificate for 127.0.0.1 signed by
+// it into dir, and returns the CA, certificate and key file names.
+func writeCerts(t *testing.T, dir string) (caFile, certFile, keyFile string) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "load-test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "ocs.load-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name, block string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: block, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	return write("ca.pem", "CERTIFICATE", caDER),
+		write("cert.pem", "CERTIFICATE", leafDER),
+		write("key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+// startTLSOCS serves the fake OCS over TLS on a loopback port.
+func startTLSOCS(t *testing.T, certFile, keyFile string) string {
+	t.Helper()
+	settings := ocs.DefaultSettings()
+	settings.Network = TransportTLS
+	settings.Addr = "127.0.0.1:0"
+	settings.CertFile = certFile
+	settings.KeyFile = keyFile
+	server, err := ocs.New(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := server.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(server.Close)
+	return addr.String()
+}
+
+func tlsPeer(addr string, tlsConfig TLSConfig) PeerConfig {
+	peer := DefaultPeerConfig()
+	peer.Address = addr
+	peer.Network = TransportTLS
+	peer.TLS = tlsConfig
+	peer.WatchdogInterval = 0
+	return peer
+}
+
+func TestTLSTransport(t *testing.T) {
+	caFile, certFile, keyFile := writeCerts(t, t.TempDir())
+	addr := startTLSOCS(t, certFile, keyFile)
+
+	hopIDs, sessions := new(sync.Map), new(sync.Map)
+	recorder := report.NewRecorder()
+	router, err := NewRouter(tlsPeer(addr, TLSConfig{CAFile: caFile}), 1, hopIDs, sessions, recorder)
+	if err != nil {
+		t.Fatalf("dial with trusted CA: %v", err)
+	}
+	defer router.Close()
+
+	client := NewDiameterClient(router, hopIDs, sessions, 5*time.Second, recorder, DefaultConfig(), nil, nil, nil)
+	answer, err := client.InitData(models.NewAccountID(1), NewSession("tls-test"))
+	if err != nil {
+		t.Fatalf("CCR-I over TLS: %v", err)
+	}
+	if answer.Code() != ResultSuccess {
+		t.Errorf("CCR-I over TLS answered %d, want %d", answer.Code(), ResultSuccess)
+	}
+}
+
+func TestTLSTransportVerifiesServer(t *testing.T) {
+	caFile, certFile, keyFile := writeCerts(t, t.TempDir())
+	addr := startTLSOCS(t, certFile, keyFile)
+
+	tests := []struct {
+		name string
+		tls  TLSConfig
+		ok   bool
+	}{
+		{name: "untrusted CA", tls: TLSConfig{}},
+		{name: "wrong server name", tls: TLSConfig{CAFile: caFile, ServerName: "other.load-test"}},
+		{name: "insecure", tls: TLSConfig{InsecureSkipVerify: true}, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peer := tlsPeer(addr, tt.tls)
+			conn, err := NewConnection(newClient(peer, new(sync.Map), new(sync.Map)), peer)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("dial: %v", err)
+				}
+				conn.Close()
+				return
+			}
+			if err == nil {
+				conn.Close()
+				t.Fatal("dial succeeded, want a certificate verification error")
+			}
+			if !isPermanent(err) {
+				t.Errorf("dial error %v, want a certificate verification error", err)
+			}
+		})
+	}
+}
+
+func TestParseTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		err  bool
+	}{
+		{name: "tcp", want: TransportTCP},
+		{name: "sctp", want: TransportSCTP},
+		{name: "tls", want: TransportTLS},
+		{name: "tls-over-tcp", want: TransportTLS},
+		{name: "udp", err: true},
+		{name: "", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTransport(tt.name)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ParseTransport(%q) = %q, want an error", tt.name, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseTransport(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknownNetworkIsPermanent(t *testing.T) {
+	peer := DefaultPeerConfig()
+	peer.Address = "127.0.0.1:1"
+	peer.Network = "udp-lite"
+	_, err := NewConnection(newClient(peer, new(sync.Map), new(sync.Map)), peer)
+	if err == nil || !isPermanent(err) {
+		t.Errorf("dial over %s: %v, want a permanent error", peer.Network, err)
+	}
+}
@@ -0,0 +1,142 @@
+package diameter
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Usage is what a CCR reports in Used-Service-Unit.
+type Usage struct {
+	InputOctets  uint64
+	OutputOctets uint64
+	Time         uint32
+	// Reason is the Reporting-Reason sent with the usage.
+	Reason uint32
+}
+
+// Fixed usages reported when the policy is fixed or nothing was granted yet.
+var (
+	fixedDataUpdateUsage    = Usage{InputOctets: 53362, OutputOctets: 5190705, Reason: ReportingReasonQuotaExhausted}
+	fixedDataTerminateUsage = Usage{InputOctets: 53362, OutputOctets: 51904, Reason: ReportingReasonFinal}
+	fixedIMSUsage           = Usage{Time: 5}
+)
+
+// uplinkShare splits a granted total into input and output octets, roughly
+// the ratio of the fixed data usage.
+const uplinkShare = 0.01
+
+type UsageMode string
+
+const (
+	UsageFixed    UsageMode = "fixed"
+	UsageAll      UsageMode = "all"
+	UsageFraction UsageMode = "fraction"
+	UsageRandom   UsageMode = "random"
+	UsageExceed   UsageMode = "exceed"
+)
+
+// UsagePolicy decides how much of the last Granted-Service-Unit a session
+// reports as used in its next CCR.
+type UsagePolicy struct {
+	Mode UsageMode
+	// Factor is the share of the grant used by fraction (0-1) and exceed (>1).
+	Factor float64
+}
+
+// ParseUsagePolicy parses "fixed", "all", "random", "fraction:0.5" or
+// "exceed:1.2".
+func ParseUsagePolicy(spec string) (UsagePolicy, error) {
+	mode, arg, hasArg := strings.Cut(spec, ":")
+	p := UsagePolicy{Mode: UsageMode(mode)}
+	switch p.Mode {
+	case "", UsageFixed:
+		p.Mode = UsageFixed
+	case UsageAll, UsageRandom:
+	case UsageFraction, UsageExceed:
+		p.Factor = 0.5
+		if p.Mode == UsageExceed {
+			p.Factor = 1.1
+		}
+		if hasArg {
+			f, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return p, fmt.Errorf("invalid %s factor %q", mode, arg)
+			}
+			p.Factor = f
+		}
+		if p.Mode == UsageFraction && (p.Factor < 0 || p.Factor > 1) {
+			return p, fmt.Errorf("fraction factor must be within 0 and 1, got %v", p.Factor)
+		}
+		if p.Mode == UsageExceed && p.Factor <= 1 {
+			return p, fmt.Errorf("exceed factor must be above 1, got %v", p.Factor)
+		}
+		return p, nil
+	default:
+		return p, fmt.Errorf("unknown usage policy %q", mode)
+	}
+	if hasArg {
+		return p, fmt.Errorf("usage policy %s takes no factor", mode)
+	}
+	return p, nil
+}
+
+func (p UsagePolicy) String() string {
+	if p.Mode == UsageFraction || p.Mode == UsageExceed {
+		return fmt.Sprintf("%s:%v", p.Mode, p.Factor)
+	}
+	return string(p.Mode)
+}
+
+// UnmarshalText lets the policy be set from config files and flags.
+func (p *UsagePolicy) UnmarshalText(text []byte) error {
+	v, err := ParseUsagePolicy(string(text))
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
+
+func (p UsagePolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// consume applies the policy to one granted amount.
+func (p UsagePolicy) consume(granted uint64) uint64 {
+	switch p.Mode {
+	case UsageFraction, UsageExceed:
+		return uint64(float64(granted) * p.Factor)
+	case UsageRandom:
+		if granted == 0 {
+			return 0
+		}
+		return uint64(rand.Int63n(int64(granted) + 1))
+	}
+	return granted
+}
+
+// Use returns the usage to report against the session's last grant, or
+// fallback when the policy is fixed or no grant has been received.
+func (p UsagePolicy) Use(s *Session, fallback Usage) Usage {
+	g, ok := s.Granted()
+	if p.Mode == UsageFixed || p.Mode == "" || !ok {
+		return fallback
+	}
+
+	u := Usage{Reason: fallback.Reason}
+	switch {
+	case g.InputOctets > 0 || g.OutputOctets > 0:
+		u.InputOctets = p.consume(g.InputOctets)
+		u.OutputOctets = p.consume(g.OutputOctets)
+	case g.TotalOctets > 0:
+		total := p.consume(g.TotalOctets)
+		u.InputOctets = uint64(float64(total) * uplinkShare)
+		u.OutputOctets = total - u.InputOctets
+	}
+	if g.Time > 0 {
+		u.Time = uint32(p.consume(uint64(g.Time)))
+	}
+	return u
+}
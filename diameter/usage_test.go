@@ -0,0 +1,99 @@
+package diameter
+
+import "testing"
+
+func TestParseUsagePolicy(t *testing.T) {
+	tests := []struct {
+		spec string
+		want UsagePolicy
+		err  bool
+	}{
+		{spec: "", want: UsagePolicy{Mode: UsageFixed}},
+		{spec: "fixed", want: UsagePolicy{Mode: UsageFixed}},
+		{spec: "all", want: UsagePolicy{Mode: UsageAll}},
+		{spec: "random", want: UsagePolicy{Mode: UsageRandom}},
+		{spec: "fraction", want: UsagePolicy{Mode: UsageFraction, Factor: 0.5}},
+		{spec: "fraction:0.25", want: UsagePolicy{Mode: UsageFraction, Factor: 0.25}},
+		{spec: "fraction:1", want: UsagePolicy{Mode: UsageFraction, Factor: 1}},
+		{spec: "exceed", want: UsagePolicy{Mode: UsageExceed, Factor: 1.1}},
+		{spec: "exceed:1.5", want: UsagePolicy{Mode: UsageExceed, Factor: 1.5}},
+
+		{spec: "half", err: true},
+		{spec: "fraction:lots", err: true},
+		{spec: "fraction:1.5", err: true},
+		{spec: "fraction:-0.1", err: true},
+		{spec: "exceed:1", err: true},
+		{spec: "exceed:0.5", err: true},
+		{spec: "all:0.5", err: true},
+		{spec: "fixed:1", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseUsagePolicy(tt.spec)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ParseUsagePolicy(%q) = %v, want an error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseUsagePolicy(%q): %v", tt.spec, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseUsagePolicy(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+			if again, err := ParseUsagePolicy(got.String()); err != nil || again != got {
+				t.Errorf("ParseUsagePolicy(%q) = %+v, %v, want it to round-trip", got.String(), again, err)
+			}
+		})
+	}
+}
+
+func TestUsagePolicyUse(t *testing.T) {
+	fallback := Usage{InputOctets: 1, OutputOctets: 2, Reason: ReportingReasonQuotaExhausted}
+	granted := func(g GrantedServiceUnit) *Session {
+		s := NewSession("usage-test")
+		s.Grant(&Answer{Services: []ServiceCredit{{Granted: g}}})
+		return s
+	}
+	tests := []struct {
+		name    string
+		policy  UsagePolicy
+		session *Session
+		want    Usage
+	}{
+		{name: "fixed", policy: UsagePolicy{Mode: UsageFixed}, session: granted(GrantedServiceUnit{TotalOctets: 1000}), want: fallback},
+		{name: "no grant", policy: UsagePolicy{Mode: UsageAll}, session: NewSession("usage-test"), want: fallback},
+		{
+			name:    "all of split octets",
+			policy:  UsagePolicy{Mode: UsageAll},
+			session: granted(GrantedServiceUnit{InputOctets: 100, OutputOctets: 900, Time: 60}),
+			want:    Usage{InputOctets: 100, OutputOctets: 900, Time: 60, Reason: ReportingReasonQuotaExhausted},
+		},
+		{
+			name:    "all of total octets",
+			policy:  UsagePolicy{Mode: UsageAll},
+			session: granted(GrantedServiceUnit{TotalOctets: 1000}),
+			want:    Usage{InputOctets: 10, OutputOctets: 990, Reason: ReportingReasonQuotaExhausted},
+		},
+		{
+			name:    "fraction",
+			policy:  UsagePolicy{Mode: UsageFraction, Factor: 0.5},
+			session: granted(GrantedServiceUnit{InputOctets: 100, OutputOctets: 900, Time: 60}),
+			want:    Usage{InputOctets: 50, OutputOctets: 450, Time: 30, Reason: ReportingReasonQuotaExhausted},
+		},
+		{
+			name:    "exceed",
+			policy:  UsagePolicy{Mode: UsageExceed, Factor: 1.5},
+			session: granted(GrantedServiceUnit{Time: 60}),
+			want:    Usage{Time: 90, Reason: ReportingReasonQuotaExhausted},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Use(tt.session, fallback); got != tt.want {
+				t.Errorf("Use = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
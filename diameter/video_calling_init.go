@@ -12,22 +12,19 @@ import (
 
 // BuildVideoCallingInitSessionCCR builds a CCR message for a Video Calling scenario.
 func BuildVideoCallingInitSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
 	phoneNumberCalling string,
 	phoneNumberCalled string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC: "020",
-		MCC: "418",
-	}
-
 	// For VendorSpecificApplicationId grouping (commonly code=260)
 	const (
 		VendorSpecificApplicationID = avp.VendorSpecificApplicationID
 	)
 
 	// Accounting-Record-Type (480) => enumerated(2) for INTERIM
-	// Accounting-Record-Number (485) => e.g., 0
+	// Accounting-Record-Number (485) => seq.RecordNumber
 	artAVP := avp.AccountingRecordType   // 480
 	arnAVP := avp.AccountingRecordNumber // 485
 
@@ -88,9 +85,9 @@ func BuildVideoCallingInitSessionCCR(
 		datatype.DiameterIdentity(destRealmVal),
 	)
 
-	// 2) Accounting-Record-Type = 2, Accounting-Record-Number = 0
+	// 2) Accounting-Record-Type = 2, Accounting-Record-Number=seq.RecordNumber
 	m.NewAVP(artAVP, avp.Mbit, 0, datatype.Enumerated(2))
-	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(seq.RecordNumber))
 
 	// 3) User-Name
 	userNameVal := fmt.Sprintf(
@@ -284,8 +281,8 @@ func BuildVideoCallingInitSessionCCR(
 	// 7) CC-Request-Type=1 (INITIAL_REQUEST)
 	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(1))
 
-	// 8) CC-Request-Number=0
-	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(0))
+	// 8) CC-Request-Number=seq.RequestNumber
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(seq.RequestNumber))
 
 	// 9) Event-Timestamp
 	m.NewAVP(avp.EventTimestamp, avp.Mbit, 0, datatype.Time(time.Now()))
@@ -12,15 +12,14 @@ import (
 
 // BuildVideoCallingTerminateSessionCCR parallels your Node.js "videoCallingTerminate" function in Go.
 func BuildVideoCallingTerminateSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
+	used Usage,
+	cause uint32,
 	phoneNumberCalling string,
 	phoneNumberCalled string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC: "020",
-		MCC: "418",
-	}
-
 	// Create a CCR message: command code = 272 (Credit-Control), app ID = 4
 	m := diam.NewRequest(diam.CreditControl, 4, dict.Default)
 
@@ -78,7 +77,7 @@ func BuildVideoCallingTerminateSessionCCR(
 	artAVP := avp.AccountingRecordType   // 480
 	arnAVP := avp.AccountingRecordNumber // 485
 	m.NewAVP(artAVP, avp.Mbit, 0, datatype.Enumerated(4))
-	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(seq.RecordNumber))
 
 	// User-Name => sip:<phoneNumberCalling>...
 	userNameVal := fmt.Sprintf(
@@ -273,8 +272,8 @@ func BuildVideoCallingTerminateSessionCCR(
 	// 4) CC-Request-Type=3 => TERMINATE_REQUEST
 	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(3))
 
-	// CC-Request-Number=9
-	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(9))
+	// CC-Request-Number=seq.RequestNumber
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(seq.RequestNumber))
 
 	// Event-Timestamp => now
 	m.NewAVP(avp.EventTimestamp, avp.Mbit, 0, datatype.Time(time.Now()))
@@ -344,7 +343,7 @@ func BuildVideoCallingTerminateSessionCCR(
 							avp.CCTime,
 							avp.Mbit,
 							0,
-							datatype.Unsigned32(5),
+							datatype.Unsigned32(used.Time),
 						),
 					},
 				},
@@ -362,12 +361,12 @@ func BuildVideoCallingTerminateSessionCCR(
 		msccGrouped,
 	)
 
-	// 9) TerminationCause=1 => DIAM_USER_REQUESTED
+	// 9) TerminationCause=cause (1 => DIAMETER_LOGOUT)
 	m.NewAVP(
 		avp.TerminationCause,
 		avp.Mbit,
 		0,
-		datatype.Enumerated(1),
+		datatype.Enumerated(cause),
 	)
 
 	// Return the completed CCR
@@ -12,14 +12,13 @@ import (
 
 // BuildVideoCallingUpdateSessionCCR parallels your Node.js "videoCallingUpdate" function in Go.
 func BuildVideoCallingUpdateSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
+	used Usage,
 	phoneNumberCalling string,
 	phoneNumberCalled string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC: "020",
-		MCC: "418",
-	}
 	// Placeholder constants for IMS/3GPP-specific AVPs
 	// Adjust them to match your dictionary.
 
@@ -76,11 +75,11 @@ func BuildVideoCallingUpdateSessionCCR(
 		datatype.DiameterIdentity(destRealmVal),
 	)
 
-	// Accounting-Record-Type=3 => STOP, Accounting-Record-Number=0
+	// Accounting-Record-Type=3 => STOP, Accounting-Record-Number=seq.RecordNumber
 	artAVP := avp.AccountingRecordType   // 480
 	arnAVP := avp.AccountingRecordNumber // 485
 	m.NewAVP(artAVP, avp.Mbit, 0, datatype.Enumerated(3))
-	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(seq.RecordNumber))
 
 	// User-Name => sip:<phoneNumberCalling>...
 	userNameVal := fmt.Sprintf(
@@ -263,9 +262,9 @@ func BuildVideoCallingUpdateSessionCCR(
 	}
 	m.NewAVP(avp.VendorSpecificApplicationID, avp.Mbit, 0, vsaiGrouped)
 
-	// 4) CC-Request-Type=2 (UPDATE_REQUEST), CC-Request-Number=1
+	// 4) CC-Request-Type=2 (UPDATE_REQUEST), CC-Request-Number=seq.RequestNumber
 	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(2))
-	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(1))
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(seq.RequestNumber))
 
 	// 5) Event-Timestamp => now
 	m.NewAVP(avp.EventTimestamp, avp.Mbit, 0, datatype.Time(time.Now()))
@@ -354,7 +353,7 @@ func BuildVideoCallingUpdateSessionCCR(
 							avp.CCTime,
 							avp.Mbit,
 							0,
-							datatype.Unsigned32(5),
+							datatype.Unsigned32(used.Time),
 						),
 					},
 				},
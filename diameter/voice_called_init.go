@@ -14,18 +14,15 @@ import (
 //   - Creates a CCR message with the AVPs shown in your snippet.
 //   - The “client.connect()” and “client.send()” aspects are left to your environment.
 func BuildVoiceCalledInitSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
 	phoneNumberCalling string,
 	phoneNumberCalled string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC: "020",
-		MCC: "418",
-	}
-
 	// 3GPP IMS + Vendor usage
 
-	// Accounting-Record-Type(480)=2, Accounting-Record-Number(485)=0
+	// Accounting-Record-Type(480)=2, Accounting-Record-Number(485)=seq.RecordNumber
 	artAVP := avp.AccountingRecordType   // 480
 	arnAVP := avp.AccountingRecordNumber // 485
 
@@ -74,9 +71,9 @@ func BuildVoiceCalledInitSessionCCR(
 		datatype.DiameterIdentity(destRealmVal),
 	)
 
-	// Accounting-Record-Type=2, Accounting-Record-Number=0
+	// Accounting-Record-Type=2, Accounting-Record-Number=seq.RecordNumber
 	m.NewAVP(artAVP, avp.Mbit, 0, datatype.Enumerated(2))
-	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(seq.RecordNumber))
 
 	// User-Name => phoneNumberCalled (per your snippet)
 	m.NewAVP(
@@ -246,9 +243,9 @@ func BuildVoiceCalledInitSessionCCR(
 	}
 	m.NewAVP(avp.VendorSpecificApplicationID, avp.Mbit, 0, vsaiGrouped)
 
-	// 4) CC-Request-Type=1 (INITIAL_REQUEST), CC-Request-Number=0
+	// 4) CC-Request-Type=1 (INITIAL_REQUEST), CC-Request-Number=seq.RequestNumber
 	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(1))
-	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(seq.RequestNumber))
 
 	// 5) Event-Timestamp => Now
 	m.NewAVP(avp.EventTimestamp, avp.Mbit, 0, datatype.Time(time.Now()))
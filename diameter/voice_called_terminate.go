@@ -12,14 +12,14 @@ import (
 
 // BuildVoiceCalledTerminateSessionCCR parallels your Node.js "voiceCalledTerminate" function in Go.
 func BuildVoiceCalledTerminateSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
+	used Usage,
+	cause uint32,
 	phoneNumberCalling string,
 	phoneNumberCalled string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC: "020",
-		MCC: "418",
-	}
 	// Some placeholder IMS/3GPP AVP codes (adjust to your dictionary).
 
 	// Create a CCR (Command-Code=272, Application-ID=4).
@@ -73,7 +73,7 @@ func BuildVoiceCalledTerminateSessionCCR(
 	artAVP := avp.AccountingRecordType   // 480 in base
 	arnAVP := avp.AccountingRecordNumber // 485
 	m.NewAVP(artAVP, avp.Mbit, 0, datatype.Enumerated(4))
-	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(seq.RecordNumber))
 
 	// User-Name => "tel:<phoneNumberCalled>"
 	userNameVal := fmt.Sprintf("tel:%s", phoneNumberCalled)
@@ -250,8 +250,8 @@ func BuildVoiceCalledTerminateSessionCCR(
 	// 4) CC-Request-Type=3 => TERMINATE_REQUEST
 	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(3))
 
-	// CC-Request-Number=9
-	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(9))
+	// CC-Request-Number=seq.RequestNumber
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(seq.RequestNumber))
 
 	// Event-Timestamp => now
 	m.NewAVP(avp.EventTimestamp, avp.Mbit, 0, datatype.Time(time.Now()))
@@ -316,7 +316,7 @@ func BuildVoiceCalledTerminateSessionCCR(
 							avp.CCTime,
 							avp.Mbit,
 							0,
-							datatype.Unsigned32(5),
+							datatype.Unsigned32(used.Time),
 						),
 					},
 				},
@@ -334,12 +334,12 @@ func BuildVoiceCalledTerminateSessionCCR(
 		msccGrouped,
 	)
 
-	// 9) TerminationCause=1 => DIAM_USER_REQUESTED (commonly used value)
+	// 9) TerminationCause=cause (1 => DIAMETER_LOGOUT)
 	m.NewAVP(
 		avp.TerminationCause,
 		avp.Mbit,
 		0,
-		datatype.Enumerated(1),
+		datatype.Enumerated(cause),
 	)
 
 	// Return the fully constructed CCR message
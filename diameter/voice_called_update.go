@@ -12,15 +12,13 @@ import (
 
 // BuildVoiceCalledUpdateSessionCCR parallels your Node.js "voiceCalledUpdate" function in Go.
 func BuildVoiceCalledUpdateSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
+	used Usage,
 	phoneNumberCalling string,
 	phoneNumberCalled string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC: "020",
-		MCC: "418",
-	}
-
 	// Command Code=272 (Credit-Control), App ID=4 (commonly used for Gx or IMS).
 	// Your snippet uses CCR with Accounting-Record-Type, which some operators do in IMS flows.
 	m := diam.NewRequest(diam.CreditControl, 4, dict.Default)
@@ -75,11 +73,11 @@ func BuildVoiceCalledUpdateSessionCCR(
 		datatype.DiameterIdentity(destRealmVal),
 	)
 
-	// Accounting-Record-Type=3 => STOP; Accounting-Record-Number=0
+	// Accounting-Record-Type=3 => STOP; Accounting-Record-Number=seq.RecordNumber
 	artAVP := avp.AccountingRecordType   // 480
 	arnAVP := avp.AccountingRecordNumber // 485
 	m.NewAVP(artAVP, avp.Mbit, 0, datatype.Enumerated(3))
-	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(seq.RecordNumber))
 
 	// User-Name => phoneNumberCalled
 	m.NewAVP(
@@ -253,9 +251,9 @@ func BuildVoiceCalledUpdateSessionCCR(
 	}
 	m.NewAVP(avp.VendorSpecificApplicationID, avp.Mbit, 0, vsaiGrouped)
 
-	// 4) CC-Request-Type=2 (UPDATE_REQUEST), CC-Request-Number=2
+	// 4) CC-Request-Type=2 (UPDATE_REQUEST), CC-Request-Number=seq.RequestNumber
 	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(2))
-	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(2))
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(seq.RequestNumber))
 
 	// 5) Event-Timestamp => now
 	m.NewAVP(avp.EventTimestamp, avp.Mbit, 0, datatype.Time(time.Now()))
@@ -346,7 +344,7 @@ func BuildVoiceCalledUpdateSessionCCR(
 							avp.CCTime,
 							avp.Mbit,
 							0,
-							datatype.Unsigned32(5),
+							datatype.Unsigned32(used.Time),
 						),
 					},
 				},
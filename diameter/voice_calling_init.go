@@ -12,15 +12,12 @@ import (
 
 // BuildVoiceCallingInitSessionCCR builds a CCR request for IMS voice calling.
 func BuildVoiceCallingInitSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
 	phoneNumberCalling string,
 	phoneNumberCalled string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC: "020",
-		MCC: "418",
-	}
-
 	// -----------------------------
 
 	// For VendorSpecificApplicationId grouping
@@ -75,12 +72,11 @@ func BuildVoiceCallingInitSessionCCR(
 	)
 
 	// Destination-Host
-	// Example: "hssocs.voiceblue.com"
 	m.NewAVP(
 		avp.DestinationHost,
 		avp.Mbit,
 		0,
-		datatype.DiameterIdentity("hssocs.voiceblue.com"),
+		datatype.DiameterIdentity(cfg.IMSDestinationHost),
 	)
 
 	// Destination-Realm
@@ -103,7 +99,7 @@ func BuildVoiceCallingInitSessionCCR(
 
 	// Accounting-Record-Number (AVP=485)
 	arnAVP := avp.AccountingRecordNumber // 485 in base
-	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(seq.RecordNumber))
 
 	// User-Name (AVP=1)
 	userNameVal := fmt.Sprintf(
@@ -301,8 +297,8 @@ func BuildVoiceCallingInitSessionCCR(
 	// 5) CC-Request-Type (416)=1 => INITIAL_REQUEST
 	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(1))
 
-	// CC-Request-Number (415)=0
-	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(0))
+	// CC-Request-Number (415)=seq.RequestNumber
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(seq.RequestNumber))
 
 	// Event-Timestamp (55)
 	m.NewAVP(avp.EventTimestamp, avp.Mbit, 0, datatype.Time(time.Now()))
@@ -12,15 +12,14 @@ import (
 
 // BuildVoiceCallingTerminateSessionCCR parallels your Node.js "voiceCallingTerminate" code in Go.
 func BuildVoiceCallingTerminateSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
+	used Usage,
+	cause uint32,
 	phoneNumberCalling string,
 	phoneNumberCalled string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC: "020",
-		MCC: "418",
-	}
-
 	// Create a CCR message: Command-Code=272 (Credit-Control), Application-ID=4
 	m := diam.NewRequest(diam.CreditControl, 4, dict.Default)
 
@@ -79,7 +78,7 @@ func BuildVoiceCallingTerminateSessionCCR(
 	artAVP := avp.AccountingRecordType   // 480
 	arnAVP := avp.AccountingRecordNumber // 485
 	m.NewAVP(artAVP, avp.Mbit, 0, datatype.Enumerated(4))
-	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(seq.RecordNumber))
 
 	// User-Name => sip:<phoneNumberCalling>...
 	userNameVal := fmt.Sprintf(
@@ -251,8 +250,8 @@ func BuildVoiceCallingTerminateSessionCCR(
 	// 4) CC-Request-Type=3 => TERMINATE_REQUEST
 	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(3))
 
-	// CC-Request-Number=9
-	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(9))
+	// CC-Request-Number=seq.RequestNumber
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(seq.RequestNumber))
 
 	// Event-Timestamp => now
 	m.NewAVP(avp.EventTimestamp, avp.Mbit, 0, datatype.Time(time.Now()))
@@ -317,7 +316,7 @@ func BuildVoiceCallingTerminateSessionCCR(
 							avp.CCTime,
 							avp.Mbit,
 							0,
-							datatype.Unsigned32(5),
+							datatype.Unsigned32(used.Time),
 						),
 					},
 				},
@@ -335,12 +334,12 @@ func BuildVoiceCallingTerminateSessionCCR(
 		msccGrouped,
 	)
 
-	// 9) TerminationCause=1 => e.g., DIAM_USER_REQUESTED
+	// 9) TerminationCause=cause (1 => DIAMETER_LOGOUT)
 	m.NewAVP(
 		avp.TerminationCause,
 		avp.Mbit,
 		0,
-		datatype.Enumerated(1),
+		datatype.Enumerated(cause),
 	)
 
 	// Return the built CCR
@@ -12,15 +12,13 @@ import (
 
 // BuildVoiceCallingUpdateSessionCCR mirrors your "voiceCallingUpdate" snippet in Go.
 func BuildVoiceCallingUpdateSessionCCR(
+	cfg Config,
 	sessionID string,
+	seq Sequence,
+	used Usage,
 	phoneNumberCalling string,
 	phoneNumberCalled string,
 ) *diam.Message {
-	cfg := &Config{
-		MNC: "020",
-		MCC: "418",
-	}
-
 	// Some placeholders for IMS-specific AVPs if not in dict.Default
 
 	// Potentially for IMS flows:
@@ -63,7 +61,7 @@ func BuildVoiceCallingUpdateSessionCCR(
 	artAVP := avp.AccountingRecordType   // 480
 	arnAVP := avp.AccountingRecordNumber // 485
 	m.NewAVP(artAVP, avp.Mbit, 0, datatype.Enumerated(3))
-	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(0))
+	m.NewAVP(arnAVP, avp.Mbit, 0, datatype.Unsigned32(seq.RecordNumber))
 
 	// User-Name => sip:<phoneNumberCalling>@...
 	userNameVal := fmt.Sprintf(
@@ -219,9 +217,9 @@ func BuildVoiceCallingUpdateSessionCCR(
 	}
 	m.NewAVP(avp.VendorSpecificApplicationID, avp.Mbit, 0, vsaiGrouped)
 
-	// 4) CC-Request-Type=2 (UPDATE_REQUEST), CC-Request-Number=3
+	// 4) CC-Request-Type=2 (UPDATE_REQUEST), CC-Request-Number=seq.RequestNumber
 	m.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(2))
-	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(3))
+	m.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(seq.RequestNumber))
 
 	// 5) Event-Timestamp => now
 	m.NewAVP(avp.EventTimestamp, avp.Mbit, 0, datatype.Time(time.Now()))
@@ -306,7 +304,7 @@ func BuildVoiceCallingUpdateSessionCCR(
 							avp.CCTime,
 							avp.Mbit,
 							0,
-							datatype.Unsigned32(5),
+							datatype.Unsigned32(used.Time),
 						),
 					},
 				},
@@ -0,0 +1,108 @@
+package diameter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MHG14/go-diameter/v4/diam"
+	"github.com/MHG14/go-diameter/v4/diam/avp"
+	"github.com/MHG14/go-diameter/v4/diam/datatype"
+	"github.com/MHG14/go-diameter/v4/diam/dict"
+	log "github.com/sirupsen/logrus"
+)
+
+// PeerState is the state of one peer connection, after the watchdog state
+// machine of RFC 3539 3.4.1.
+type PeerState int
+
+const (
+	// PeerOkay connections answer their watchdogs and carry traffic.
+	PeerOkay PeerState = iota
+	// PeerSuspect connections missed a DWA; traffic fails over to the
+	// other connections while there are any.
+	PeerSuspect
+	// PeerDown connections are closed and being redialed.
+	PeerDown
+	// PeerReopen connections were redialed and wait for their first DWA.
+	PeerReopen
+)
+
+var peerStateNames = [...]string{"okay", "suspect", "down", "reopen"}
+
+func (s PeerState) String() string {
+	return peerStateNames[s]
+}
+
+// watchdog sends a DWR on the pool's connection i every WatchdogInterval
+// until conn closes. After WatchdogFailures DWAs in a row are missed the
+// connection is closed and redialed.
+func (p *Pool) watchdog(i int, conn diam.Conn) {
+	closed := conn.(diam.CloseNotifier).CloseNotify()
+	failures := 0
+	next := p.peer.WatchdogInterval
+	if p.state(i) == PeerReopen {
+		// A reopened connection only takes traffic again after a DWA.
+		next = 0
+	}
+	for {
+		select {
+		case <-closed:
+			return
+		case <-p.done:
+			return
+		case <-time.After(next):
+		}
+		next = p.peer.WatchdogInterval
+
+		rtt, err := p.dwr(conn)
+		p.recorder.RecordWatchdog(p.peer.Address, rtt, err)
+		if err == nil {
+			failures = 0
+			p.setState(i, PeerOkay)
+			continue
+		}
+
+		failures++
+		log.Warnf("peer %s connection %d: watchdog %d/%d failed: %v", p.peer.Address, i, failures, p.peer.WatchdogFailures, err)
+		if failures < p.peer.WatchdogFailures {
+			p.setState(i, PeerSuspect)
+			continue
+		}
+		p.lost(i, conn)
+		return
+	}
+}
+
+// dwr sends a Device-Watchdog-Request and returns the round trip of its
+// answer.
+func (p *Pool) dwr(conn diam.Conn) (time.Duration, error) {
+	m := diam.NewRequest(diam.DeviceWatchdog, 0, dict.Default)
+	m.NewAVP(avp.OriginHost, avp.Mbit, 0, datatype.DiameterIdentity(p.peer.OriginHost))
+	m.NewAVP(avp.OriginRealm, avp.Mbit, 0, datatype.DiameterIdentity(p.peer.OriginRealm))
+
+	hopID := m.Header.HopByHopID
+	ch := make(chan *diam.Message, 1)
+	p.hopIDs.Store(hopID, ch)
+	defer p.hopIDs.Delete(hopID)
+
+	sent := time.Now()
+	if _, err := m.WriteTo(conn); err != nil {
+		return 0, err
+	}
+	select {
+	case dwa := <-ch:
+		rtt := time.Since(sent)
+		var answer struct {
+			ResultCode uint32 `avp:"Result-Code"`
+		}
+		if err := dwa.Unmarshal(&answer); err != nil {
+			return rtt, err
+		}
+		if !IsSuccess(answer.ResultCode) {
+			return rtt, fmt.Errorf("DWA with Result-Code %s", ResultCodeName(answer.ResultCode))
+		}
+		return rtt, nil
+	case <-time.After(p.peer.WatchdogTimeout):
+		return 0, fmt.Errorf("no DWA within %v", p.peer.WatchdogTimeout)
+	}
+}
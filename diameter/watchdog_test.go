@@ -0,0 +1,109 @@
+package diameter
+
+import (
+	"testing"
+	"time"
+
+	"load-test/ocs"
+)
+
+// watchdogPeer is a peer that is watched every 30ms and given up on after
+// failures missed DWAs.
+func watchdogPeer(addr string, failures int) PeerConfig {
+	peer := tcpPeer(addr)
+	peer.WatchdogInterval = 30 * time.Millisecond
+	peer.WatchdogTimeout = 30 * time.Millisecond
+	peer.WatchdogFailures = failures
+	return peer
+}
+
+// checkTransitions checks the state changes the pool's connections went
+// through, by count.
+func checkTransitions(t *testing.T, p *Pool, want map[string]uint64) {
+	t.Helper()
+	peers := p.recorder.Summary().Peers
+	if len(peers) != 1 {
+		t.Fatalf("recorded %d peers, want 1", len(peers))
+	}
+	got := peers[0].Transitions
+	if len(got) != len(want) {
+		t.Fatalf("transitions %v, want %v", got, want)
+	}
+	for transition, n := range want {
+		if got[transition] != n {
+			t.Fatalf("transitions %v, want %v", got, want)
+		}
+	}
+}
+
+func TestWatchdogDownAndReopen(t *testing.T) {
+	_, addr := startOCS(t, ocs.DefaultSettings())
+	px := startProxy(t, addr)
+	p, err := testPool(t, watchdogPeer(px.addr(), 3), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	first := p.conns[0]
+
+	// The peer stops answering: one missed DWA makes the connection
+	// suspect, the third takes it down.
+	px.muted.Store(true)
+	waitFor(t, time.Second, "connection suspect", func() bool { return p.state(0) == PeerSuspect })
+	waitFor(t, time.Second, "connection down", func() bool { return p.state(0) == PeerDown })
+	if _, err := p.Get(); err != ErrNoConnection {
+		t.Errorf("Get while down = %v, want %v", err, ErrNoConnection)
+	}
+
+	// Once the peer answers again the redialed connection reopens and is
+	// okay after its first DWA.
+	px.muted.Store(false)
+	waitFor(t, reconnectInterval+time.Second, "connection okay again", func() bool { return p.state(0) == PeerOkay })
+	checkTransitions(t, p, map[string]uint64{"okay->suspect": 1, "suspect->down": 1, "down->reopen": 1, "reopen->okay": 1})
+	if conn, err := p.Get(); err != nil || conn == first {
+		t.Errorf("Get after reopen = %v, %v, want the redialed connection", conn, err)
+	}
+	if failed := p.recorder.Summary().Peers[0].WatchdogFailures; failed < 3 {
+		t.Errorf("recorded %d failed watchdogs, want at least 3", failed)
+	}
+}
+
+func TestWatchdogSuspectRecovers(t *testing.T) {
+	_, addr := startOCS(t, ocs.DefaultSettings())
+	px := startProxy(t, addr)
+	p, err := testPool(t, watchdogPeer(px.addr(), 1000), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	conn := p.conns[0]
+
+	px.muted.Store(true)
+	waitFor(t, time.Second, "connection suspect", func() bool { return p.state(0) == PeerSuspect })
+	// A suspect connection still takes traffic when no other is okay.
+	if got, err := p.Get(); err != nil || got != conn {
+		t.Errorf("Get while suspect = %v, %v, want the suspect connection", got, err)
+	}
+	px.muted.Store(false)
+	waitFor(t, time.Second, "connection okay again", func() bool { return p.state(0) == PeerOkay })
+	checkTransitions(t, p, map[string]uint64{"okay->suspect": 1, "suspect->okay": 1})
+	if p.conns[0] != conn {
+		t.Error("connection was redialed, want the suspect one kept")
+	}
+}
+
+func TestNextReconnectWait(t *testing.T) {
+	var waits []time.Duration
+	for wait := reconnectInterval; len(waits) < 8; wait = nextReconnectWait(wait) {
+		waits = append(waits, wait)
+	}
+	want := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+		maxReconnectInterval, maxReconnectInterval, maxReconnectInterval,
+	}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Fatalf("reconnect waits %v, want %v", waits, want)
+		}
+	}
+}
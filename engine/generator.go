@@ -1,81 +1,318 @@
 package engine
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"load-test/config"
 	"load-test/diameter"
+	"load-test/metrics"
 	"load-test/models"
+	"load-test/monitoring"
 	"load-test/pipeline"
-	"math"
+	"load-test/report"
+	"load-test/sink"
+	"os"
 	"sync"
 	"time"
 )
 
-const batchSize = 1
-
 const updateIterations = 2
 const sleepTimes = 1 * time.Second
 
-func worker(task chan models.AccountID, wg *sync.WaitGroup, numberOfAccounts int, client diameter.Client) {
-	defer wg.Done()
-	for id := range task {
-		pipeline.NewAccount(updateIterations, numberOfAccounts, sleepTimes, client, id).Run()
-		//fmt.Printf("%s is Done\n", id)
-	}
+// neverTerminatedSamples is how many open sessions the report lists.
+const neverTerminatedSamples = 10
+
+// runner holds what every account session of a run shares.
+type runner struct {
+	mix              *pipeline.Mix
+	recorder         *report.Recorder
+	metrics          *metrics.Metrics
+	client           diameter.Client
+	numberOfAccounts int
+	abortOnTimeout   bool
+
+	// ctx is cancelled to stop the run; sessions in flight then get
+	// drainTimeout to terminate.
+	ctx          context.Context
+	cancel       context.CancelCauseFunc
+	drainTimeout time.Duration
+	// cycle starts over from the first account when all were used, until
+	// ctx is cancelled or sessionLimit sessions started.
+	cycle        bool
+	sessionLimit uint64
+
+	// stoppedBy is the first stop condition met, whether it cancelled the
+	// run or, like the session limit, only let it run out.
+	mu        sync.Mutex
+	stoppedBy error
 }
 
-func Start(numberOfAccounts int, timeout time.Duration) {
-	var err error
+// Start runs the load until it is done or ctx is cancelled. A cancelled run
+// starts no more sessions, terminates the open ones and reports what was
+// done, with the cause of the cancellation as what stopped it. An error is
+// returned when the run cannot be set up.
+func Start(ctx context.Context, cfg *config.Config, mix *pipeline.Mix, profile Profile) (*report.Summary, error) {
+	fmt.Printf("Running traffic mix: %s\n", mix)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var results sink.ResultSink
+	if cfg.Run.Results != "" {
+		var err error
+		results, err = sink.Open(cfg.Run.Results, sink.Options{DSN: cfg.DB.DSN, Description: mix.String()})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to store results")
+		}
+		defer func() {
+			if err := results.Close(); err != nil {
+				log.Errorf("some results were not stored: %v", err)
+			}
+		}()
+	}
+
 	hopIDs := new(sync.Map)
-	conn, err := diameter.NewConnection(hopIDs)
+	sessions := new(sync.Map)
+	recorder := report.NewRecorder()
+	router, err := diameter.NewRouter(cfg.PeerConfig(), cfg.Peer.Connections, hopIDs, sessions, recorder)
 	if err != nil {
-		panic(errors.Wrap(err, "unable to connect to diameter"))
+		return nil, errors.Wrap(err, "unable to connect to diameter")
+	}
+	defer router.Close()
+
+	var m *metrics.Metrics
+	if cfg.Run.MetricsAddr != "" {
+		m = metrics.New()
+		m.WatchPeers(router.ConnectionStates)
+		srv, err := m.Serve(cfg.Run.MetricsAddr)
+		if err != nil {
+			return nil, err
+		}
+		defer srv.Close()
+	}
+
+	stop := stopConditions(cfg.Stop)
+	tracker := monitoring.NewTracker(cfg.Run.StaleAfter)
+	if cfg.Run.Dashboard > 0 {
+		defer tracker.Dashboard(os.Stderr, cfg.Run.Dashboard)()
+	}
+	r := &runner{
+		mix:              mix,
+		recorder:         recorder,
+		metrics:          m,
+		client:           diameter.NewDiameterClient(router, hopIDs, sessions, cfg.Peer.Timeout, recorder, cfg.DiameterConfig(), results, tracker, m),
+		numberOfAccounts: cfg.Run.Accounts,
+		abortOnTimeout:   cfg.Run.AbortOnTimeout,
+		ctx:              ctx,
+		cancel:           cancel,
+		drainTimeout:     cfg.Run.DrainTimeout,
+		cycle:            stop.unbounded(),
+		sessionLimit:     stop.Sessions,
 	}
-	client := diameter.NewDiameterClient(conn, hopIDs, timeout)
 
-	numOfWorkers := int(math.Ceil(float64(numberOfAccounts) / batchSize))
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		stop.watch(watchCtx, r.stop, recorder)
+	}()
 
-	tasks := make(chan models.AccountID, numberOfAccounts)
+	if profile != nil {
+		r.runProfile(profile, cfg.Run.Concurrency)
+	} else {
+		r.runAll(cfg.Run.Concurrency)
+	}
+	// No condition can be met once the watch is over, so the report names
+	// the one that stopped the run, if any.
+	stopWatching()
+	<-watching
+	summary := recorder.Summary()
+	if cause := r.stopCause(); cause != nil {
+		summary.StoppedBy = cause.Error()
+	}
+	summary.States = tracker.SessionStates(neverTerminatedSamples)
+	summary.Checks = cfg.Run.Assert.Check(summary)
+	return summary, nil
+}
+
+// runAll runs one session per account with at most concurrency sessions in
+// flight. Account IDs are generated as workers ask for them, so memory does
+// not grow with the number of accounts. With cycle set the accounts are run
+// through again until the run is stopped.
+func (r *runner) runAll(concurrency int) {
+	if concurrency < 1 || concurrency > r.numberOfAccounts {
+		concurrency = r.numberOfAccounts
+	}
+
+	tasks := make(chan models.AccountID, concurrency)
 	wg := new(sync.WaitGroup)
-	wg.Add(numberOfAccounts)
-	for i := 0; i < numberOfAccounts; i++ {
-		go worker(tasks, wg, numberOfAccounts, client)
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go r.worker(tasks, wg)
 	}
+	fmt.Printf("%d workers are all up and running\n", concurrency)
 
-	fmt.Println("Workers are all up and running")
+	var started uint64
+feed:
+	for i := 1; i <= r.numberOfAccounts; i++ {
+		if r.limitReached(started) {
+			break
+		}
+		started++
+		select {
+		case tasks <- models.NewAccountID(i):
+		case <-r.ctx.Done():
+			break feed
+		}
+		if i == r.numberOfAccounts && r.cycle {
+			i = 0
+		}
+	}
+	close(tasks)
+	r.wait(wg)
+}
 
-	var accountIDs []models.AccountID
-	for i := 1; i <= numberOfAccounts; i++ {
-		id := models.NewAccountID(i)
-		accountIDs = append(accountIDs, id)
+func (r *runner) worker(tasks chan models.AccountID, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for id := range tasks {
+		if r.ctx.Err() != nil {
+			// Accounts queued before the stop are not started.
+			continue
+		}
+		r.session(id)
 	}
+}
 
-	accountsMap := make(map[int][]models.AccountID)
+// runProfile starts sessions at the rate the profile dictates, cycling
+// through the account range, and waits for in-flight sessions to finish.
+// Starting a session never waits for a free slot, as that would hide the
+// very backlog an open-loop run is meant to expose; when concurrency
+// sessions are already in flight the new one is dropped and counted.
+func (r *runner) runProfile(profile Profile, concurrency int) {
+	fmt.Printf("Running load profile: %s\n", profile)
 
-	for i := 0; i < numOfWorkers; i++ {
-		start := i * batchSize
-		end := (i + 1) * batchSize
-		if end > numberOfAccounts {
-			end = numberOfAccounts
+	var slots chan struct{}
+	if concurrency > 0 {
+		slots = make(chan struct{}, concurrency)
+	}
+	wg := new(sync.WaitGroup)
+	next := 0
+	var started uint64
+	ctx, stopPacing := context.WithCancel(r.ctx)
+	defer stopPacing()
+	pace(ctx, profile, func() {
+		if r.limitReached(started) {
+			stopPacing()
+			return
+		}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			default:
+				r.recorder.RecordDroppedSession()
+				return
+			}
 		}
-		accountsMap[i] = accountIDs[start:end]
+		started++
+		next = next%r.numberOfAccounts + 1
+		id := models.NewAccountID(next)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.session(id)
+			if slots != nil {
+				<-slots
+			}
+		}()
+	})
+	r.wait(wg)
+}
+
+// limitReached reports whether started sessions use up the session limit,
+// noting it as what stopped the run.
+func (r *runner) limitReached(started uint64) bool {
+	if r.sessionLimit == 0 || started < r.sessionLimit {
+		return false
 	}
+	r.noteStop(fmt.Errorf("stop condition: %d sessions started", started))
+	return true
+}
 
-	pusherWg := new(sync.WaitGroup)
-	pusherWg.Add(numOfWorkers)
-	for i := 0; i < numOfWorkers; i++ {
-		go pushWorker(tasks, accountsMap[i], pusherWg)
+// stop cancels the run with cause, which drains its open sessions.
+func (r *runner) stop(cause error) {
+	r.noteStop(cause)
+	r.cancel(cause)
+}
+
+// noteStop records cause as what stopped the run, unless it was already
+// stopped.
+func (r *runner) noteStop(cause error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stoppedBy == nil && r.ctx.Err() == nil {
+		r.stoppedBy = cause
 	}
+}
 
-	pusherWg.Wait()
+// stopCause returns what stopped the run: the first condition met, or else
+// the cause the run was cancelled with, e.g. a signal.
+func (r *runner) stopCause() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stoppedBy != nil {
+		return r.stoppedBy
+	}
+	if r.ctx.Err() != nil {
+		return context.Cause(r.ctx)
+	}
+	return nil
+}
 
-	close(tasks)
-	wg.Wait()
+// wait waits for the sessions in flight. Once the run is stopped they have
+// drainTimeout to terminate; those still running after it are left behind.
+func (r *runner) wait(wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-r.ctx.Done():
+	}
+	fmt.Printf("Stopping: %v, terminating open sessions\n", context.Cause(r.ctx))
+	select {
+	case <-done:
+	case <-time.After(r.drainTimeout):
+		log.Warnf("sessions still running after the %v drain timeout are left open", r.drainTimeout)
+	}
 }
 
-func pushWorker(tasks chan models.AccountID, accountIDs []models.AccountID, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for _, id := range accountIDs {
-		tasks <- id
+// session runs one account session with a scenario drawn from the mix.
+func (r *runner) session(id models.AccountID) {
+	name, scenario := r.mix.Pick()
+	r.recorder.RecordSession(name)
+	pipeline.NewAccount(scenario, r.numberOfAccounts, r.client, r.recorder, r.metrics, id, r.abortOnTimeout, r.ctx.Done()).Run()
+}
+
+// BuildMix returns the traffic mix of -mix or -scenario, or the default
+// data flow when neither is set.
+func BuildMix(cfg *config.Config) (*pipeline.Mix, error) {
+	switch {
+	case cfg.Run.Mix != "" && cfg.Run.Scenario != "":
+		return nil, errors.New("-mix and -scenario are mutually exclusive")
+	case cfg.Run.Mix != "":
+		mix, err := pipeline.ParseMix(cfg.Run.Mix, updateIterations, sleepTimes)
+		return mix, errors.Wrap(err, "invalid -mix")
+	case cfg.Run.Scenario != "":
+		scenario, err := pipeline.LoadScenario(cfg.Run.Scenario)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid -scenario")
+		}
+		return pipeline.SingleMix(scenario), nil
 	}
+	return pipeline.SingleMix(pipeline.DefaultScenario(updateIterations, sleepTimes)), nil
 }
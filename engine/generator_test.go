@@ -0,0 +1,231 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"load-test/config"
+	"load-test/diameter"
+	"load-test/ocs"
+	"load-test/report"
+)
+
+// startOCS serves the fake OCS on a loopback port, journaling the CCRs it
+// receives.
+func startOCS(t *testing.T, settings ocs.Settings) (*ocs.Server, string) {
+	t.Helper()
+	settings.Addr = "127.0.0.1:0"
+	settings.KeepRequests = true
+	server, err := ocs.New(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := server.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(server.Close)
+	return server, addr.String()
+}
+
+// testConfig is a run of accounts sessions against the OCS at addr.
+func testConfig(addr string, accounts int) *config.Config {
+	cfg := config.Default()
+	cfg.Peer.Address = addr
+	cfg.Peer.Connections = 2
+	cfg.Run.Accounts = accounts
+	cfg.Run.Concurrency = accounts
+	cfg.Run.Dashboard = 0
+	return cfg
+}
+
+// start runs cfg with the mix it describes.
+func start(t *testing.T, cfg *config.Config, profile Profile) (*report.Summary, error) {
+	t.Helper()
+	mix, err := BuildMix(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Start(context.Background(), cfg, mix, profile)
+}
+
+func TestStartDefaultFlow(t *testing.T) {
+	const accounts = 5
+	server, addr := startOCS(t, ocs.DefaultSettings())
+	summary, err := start(t, testConfig(addr, accounts), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.StoppedBy != "" {
+		t.Errorf("run stopped by %q, want it to run to completion", summary.StoppedBy)
+	}
+	if got := summary.Sessions["data"]; got != accounts {
+		t.Errorf("ran %d data sessions, want %d", got, accounts)
+	}
+	// CCR-I, two CCR-Us and a CCR-T per session.
+	const requests = 4 * accounts
+	if got := summary.ResultCodes[diameter.ResultSuccess]; got != requests || len(summary.ResultCodes) != 1 {
+		t.Errorf("result codes %v, want %d x %d", summary.ResultCodes, requests, diameter.ResultSuccess)
+	}
+	if summary.States.Terminated != accounts || summary.States.Init+summary.States.Active != 0 || summary.States.Failed != 0 {
+		t.Errorf("session states %+v, want all %d terminated", summary.States, accounts)
+	}
+	if got := len(server.Requests()); got != requests {
+		t.Errorf("OCS received %d CCRs, want %d", got, requests)
+	}
+	if !summary.Passed() {
+		t.Error("Passed() = false without assertions, want true")
+	}
+}
+
+func TestStartProfile(t *testing.T) {
+	server, addr := startOCS(t, ocs.DefaultSettings())
+	cfg := testConfig(addr, 100)
+	cfg.Run.Mix = "voice=1"
+	summary, err := start(t, cfg, NewConstantProfile(50, 200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := summary.Sessions["voice"]
+	if sessions < 5 || sessions > 15 {
+		t.Fatalf("profile of 50/s for 200ms started %d sessions, want about 10", sessions)
+	}
+	// Both legs of a call send a CCR-I, two CCR-Us and a CCR-T.
+	requests := 8 * sessions
+	if got := summary.ResultCodes[diameter.ResultSuccess]; got != requests {
+		t.Errorf("%d CCAs with %d, want %d", got, diameter.ResultSuccess, requests)
+	}
+	if got := uint64(len(server.Requests())); got != requests {
+		t.Errorf("OCS received %d CCRs, want %d", got, requests)
+	}
+	if summary.States.Terminated != 2*sessions {
+		t.Errorf("session states %+v, want both legs of %d calls terminated", summary.States, sessions)
+	}
+}
+
+func TestStartSetupErrors(t *testing.T) {
+	_, addr := startOCS(t, ocs.DefaultSettings())
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	tests := []struct {
+		name  string
+		setup func(cfg *config.Config)
+		want  string
+	}{
+		{"results sink", func(cfg *config.Config) { cfg.Run.Results = "ftp://results" }, "unable to store results"},
+		{"unreachable peer", func(cfg *config.Config) { cfg.Peer.Address = closed.Addr().String() }, "unable to connect to diameter"},
+		{"metrics address in use", func(cfg *config.Config) { cfg.Run.MetricsAddr = busy.Addr().String() }, "unable to listen for metrics"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(addr, 1)
+			tt.setup(cfg)
+			summary, err := start(t, cfg, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Start = %v, want an error about %q", err, tt.want)
+			}
+			if summary != nil {
+				t.Errorf("Start returned a summary with error %v", err)
+			}
+		})
+	}
+}
+
+func TestBuildMix(t *testing.T) {
+	tests := []struct {
+		name     string
+		mix      string
+		scenario string
+		want     string
+		err      string
+	}{
+		{name: "default", want: "data=100.0%"},
+		{name: "mix", mix: "data=3,voice=1", want: "data=75.0%,voice=25.0%"},
+		{name: "mix and scenario", mix: "data=1", scenario: "data.yaml", err: "-mix and -scenario are mutually exclusive"},
+		{name: "bad mix", mix: "data=lots", err: `invalid -mix: invalid weight "lots"`},
+		{name: "missing scenario", scenario: filepath.Join(t.TempDir(), "missing.yaml"), err: "invalid -scenario"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Run.Mix, cfg.Run.Scenario = tt.mix, tt.scenario
+			mix, err := BuildMix(cfg)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("BuildMix = %v, %v, want an error %q", mix, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := mix.String(); got != tt.want {
+				t.Errorf("BuildMix = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartStopConditions(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings func(s *ocs.Settings)
+		stop     config.Stop
+		want     string
+	}{
+		{"duration", nil, config.Stop{Duration: 300 * time.Millisecond}, "stop condition: duration 300ms reached"},
+		{"messages", nil, config.Stop{Messages: 50}, "stop condition: "},
+		{
+			"error rate",
+			func(s *ocs.Settings) { s.ErrorRate = 0.5 },
+			config.Stop{MaxErrorRate: 0.1, Window: time.Second},
+			"abort threshold: error rate ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := ocs.DefaultSettings()
+			if tt.settings != nil {
+				tt.settings(&settings)
+			}
+			_, addr := startOCS(t, settings)
+			// Left alone, the profile runs for 10s.
+			const rate, duration = 200, 10 * time.Second
+			cfg := testConfig(addr, rate*10)
+			cfg.Stop = tt.stop
+			started := time.Now()
+			summary, err := start(t, cfg, NewConstantProfile(rate, duration))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if elapsed := time.Since(started); elapsed > 5*time.Second {
+				t.Errorf("run took %v, want it stopped well before the %v profile ends", elapsed, duration)
+			}
+			if !strings.HasPrefix(summary.StoppedBy, tt.want) {
+				t.Errorf("run stopped by %q, want %q...", summary.StoppedBy, tt.want)
+			}
+			// Flows that met an error end without a CCR-T; the others are
+			// drained.
+			if open := summary.States.Init + summary.States.Active; open != 0 && tt.settings == nil {
+				t.Errorf("%d sessions left open, want them all drained", open)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const tickInterval = 10 * time.Millisecond
+
+// Profile describes an open-loop load shape: the rate at which new sessions
+// are started, independent of how fast the OCS answers.
+type Profile interface {
+	// Rate returns the target number of session starts per second at elapsed.
+	Rate(elapsed time.Duration) float64
+	Duration() time.Duration
+	String() string
+}
+
+type constantProfile struct {
+	rate     float64
+	duration time.Duration
+}
+
+func NewConstantProfile(rate float64, duration time.Duration) Profile {
+	return &constantProfile{rate: rate, duration: duration}
+}
+
+func (p *constantProfile) Rate(time.Duration) float64 { return p.rate }
+func (p *constantProfile) Duration() time.Duration    { return p.duration }
+func (p *constantProfile) String() string {
+	return fmt.Sprintf("constant %.0f/s for %v", p.rate, p.duration)
+}
+
+// rampProfile moves linearly from one rate to another over its duration.
+type rampProfile struct {
+	from, to float64
+	duration time.Duration
+}
+
+func NewRampProfile(from, to float64, duration time.Duration) Profile {
+	return &rampProfile{from: from, to: to, duration: duration}
+}
+
+func (p *rampProfile) Rate(elapsed time.Duration) float64 {
+	if p.duration <= 0 {
+		return p.to
+	}
+	frac := math.Min(float64(elapsed)/float64(p.duration), 1)
+	return p.from + (p.to-p.from)*frac
+}
+func (p *rampProfile) Duration() time.Duration { return p.duration }
+func (p *rampProfile) String() string {
+	return fmt.Sprintf("ramp %.0f/s -> %.0f/s over %v", p.from, p.to, p.duration)
+}
+
+// stepProfile raises the rate by step every interval until it reaches to.
+type stepProfile struct {
+	from, to, step float64
+	every          time.Duration
+	duration       time.Duration
+}
+
+func NewStepProfile(from, to, step float64, every, duration time.Duration) Profile {
+	return &stepProfile{from: from, to: to, step: step, every: every, duration: duration}
+}
+
+func (p *stepProfile) Rate(elapsed time.Duration) float64 {
+	if p.every <= 0 {
+		return p.from
+	}
+	rate := p.from + math.Floor(float64(elapsed)/float64(p.every))*p.step
+	if p.step >= 0 {
+		return math.Min(rate, p.to)
+	}
+	return math.Max(rate, p.to)
+}
+func (p *stepProfile) Duration() time.Duration { return p.duration }
+func (p *stepProfile) String() string {
+	return fmt.Sprintf("step %.0f/s -> %.0f/s by %.0f every %v for %v", p.from, p.to, p.step, p.every, p.duration)
+}
+
+// spikeProfile runs at a base rate and jumps to peak for a short window.
+type spikeProfile struct {
+	base, peak float64
+	at, length time.Duration
+	duration   time.Duration
+}
+
+func NewSpikeProfile(base, peak float64, at, length, duration time.Duration) Profile {
+	return &spikeProfile{base: base, peak: peak, at: at, length: length, duration: duration}
+}
+
+func (p *spikeProfile) Rate(elapsed time.Duration) float64 {
+	if elapsed >= p.at && elapsed < p.at+p.length {
+		return p.peak
+	}
+	return p.base
+}
+func (p *spikeProfile) Duration() time.Duration { return p.duration }
+func (p *spikeProfile) String() string {
+	return fmt.Sprintf("spike %.0f/s with %.0f/s at %v for %v, total %v", p.base, p.peak, p.at, p.length, p.duration)
+}
+
+// ParseProfile parses a profile spec of the form "kind:key=value,...", e.g.
+//
+//	constant:rate=2000,duration=10m
+//	ramp:from=100,to=5000,duration=5m
+//	step:from=100,to=1000,step=100,every=30s,duration=10m
+//	spike:base=100,peak=2000,at=1m,for=10s,duration=5m
+func ParseProfile(spec string) (Profile, error) {
+	kind, rest, _ := strings.Cut(spec, ":")
+	params := map[string]string{}
+	if rest != "" {
+		for _, kv := range strings.Split(rest, ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid profile parameter %q", kv)
+			}
+			params[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	p := &profileParams{values: params}
+
+	var profile Profile
+	switch kind {
+	case "constant":
+		profile = NewConstantProfile(p.rate("rate"), p.duration("duration"))
+	case "ramp":
+		profile = NewRampProfile(p.rate("from"), p.rate("to"), p.duration("duration"))
+	case "step":
+		every := p.duration("every")
+		if p.err == nil && every <= 0 {
+			p.fail(fmt.Errorf("\"every\" must be positive, got %v", every))
+		}
+		profile = NewStepProfile(p.rate("from"), p.rate("to"), p.number("step"), every, p.duration("duration"))
+	case "spike":
+		profile = NewSpikeProfile(p.rate("base"), p.rate("peak"), p.duration("at"), p.duration("for"), p.duration("duration"))
+	default:
+		return nil, fmt.Errorf("unknown load profile %q", kind)
+	}
+	if p.err != nil {
+		return nil, errors.Wrapf(p.err, "invalid %s profile", kind)
+	}
+	if profile.Duration() <= 0 {
+		return nil, fmt.Errorf("%s profile needs a positive duration", kind)
+	}
+	return profile, nil
+}
+
+type profileParams struct {
+	values map[string]string
+	err    error
+}
+
+func (p *profileParams) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// rate is a number of session starts per second, which cannot be negative.
+func (p *profileParams) rate(key string) float64 {
+	f := p.number(key)
+	if f < 0 {
+		p.fail(fmt.Errorf("%q must not be negative, got %v", key, f))
+	}
+	return f
+}
+
+func (p *profileParams) number(key string) float64 {
+	v, ok := p.values[key]
+	if !ok {
+		p.fail(fmt.Errorf("missing %q", key))
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		p.fail(errors.Wrapf(err, "parse %q", key))
+		return 0
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		p.fail(fmt.Errorf("%q must be a finite number, got %v", key, v))
+	}
+	return f
+}
+
+func (p *profileParams) duration(key string) time.Duration {
+	v, ok := p.values[key]
+	if !ok {
+		p.fail(fmt.Errorf("missing %q", key))
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		p.fail(errors.Wrapf(err, "parse %q", key))
+	}
+	return d
+}
+
+// pace calls start once per due session start until the profile's duration
+// elapses or ctx is done. Starts are scheduled from the wall clock, so a slow OCS never
+// delays them (no coordinated omission); missed ticks are caught up.
+func pace(ctx context.Context, profile Profile, start func()) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	begin := time.Now()
+	last := begin
+	var due float64
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		elapsed := now.Sub(begin)
+		if elapsed >= profile.Duration() {
+			return
+		}
+		due += profile.Rate(elapsed) * now.Sub(last).Seconds()
+		last = now
+		for ; due >= 1; due-- {
+			start()
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseProfile(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+		err  bool
+	}{
+		{spec: "constant:rate=2000,duration=10m", want: "constant 2000/s for 10m0s"},
+		{spec: "ramp:from=100,to=5000,duration=5m", want: "ramp 100/s -> 5000/s over 5m0s"},
+		{spec: "step:from=100,to=1000,step=100,every=30s,duration=10m", want: "step 100/s -> 1000/s by 100 every 30s for 10m0s"},
+		{spec: "step:from=1000,to=100,step=-100,every=30s,duration=10m", want: "step 1000/s -> 100/s by -100 every 30s for 10m0s"},
+		{spec: "spike:base=100,peak=2000,at=1m,for=10s,duration=5m", want: "spike 100/s with 2000/s at 1m0s for 10s, total 5m0s"},
+
+		{spec: "sine:rate=1,duration=1m", err: true},
+		{spec: "constant:rate=1", err: true},
+		{spec: "constant:rate=1,duration=0s", err: true},
+		{spec: "constant:rate,duration=1m", err: true},
+		{spec: "constant:rate=fast,duration=1m", err: true},
+		{spec: "constant:rate=-5,duration=1m", err: true},
+		{spec: "constant:rate=NaN,duration=1m", err: true},
+		{spec: "ramp:from=NaN,to=10,duration=1m", err: true},
+		{spec: "ramp:from=1,to=Inf,duration=1m", err: true},
+		{spec: "spike:base=-1,peak=10,at=1s,for=1s,duration=1m", err: true},
+		{spec: "step:from=1,to=10,step=1,every=0s,duration=1m", err: true},
+		{spec: "step:from=1,to=10,step=1,every=-1s,duration=1m", err: true},
+		{spec: "step:from=1,to=10,step=NaN,every=1s,duration=1m", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			profile, err := ParseProfile(tt.spec)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ParseProfile(%q) = %v, want an error", tt.spec, profile)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseProfile(%q): %v", tt.spec, err)
+			}
+			if got := profile.String(); got != tt.want {
+				t.Errorf("ParseProfile(%q) = %q, want %q", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfileRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		elapsed time.Duration
+		want    float64
+	}{
+		{"constant", NewConstantProfile(50, time.Minute), 30 * time.Second, 50},
+		{"ramp start", NewRampProfile(100, 200, time.Minute), 0, 100},
+		{"ramp middle", NewRampProfile(100, 200, time.Minute), 30 * time.Second, 150},
+		{"ramp past end", NewRampProfile(100, 200, time.Minute), 2 * time.Minute, 200},
+		{"step first", NewStepProfile(100, 300, 100, 10*time.Second, time.Minute), 5 * time.Second, 100},
+		{"step second", NewStepProfile(100, 300, 100, 10*time.Second, time.Minute), 15 * time.Second, 200},
+		{"step capped", NewStepProfile(100, 300, 100, 10*time.Second, time.Minute), 50 * time.Second, 300},
+		{"step down capped", NewStepProfile(300, 100, -100, 10*time.Second, time.Minute), 50 * time.Second, 100},
+		{"spike before", NewSpikeProfile(10, 100, time.Second, time.Second, time.Minute), 500 * time.Millisecond, 10},
+		{"spike during", NewSpikeProfile(10, 100, time.Second, time.Second, time.Minute), 1500 * time.Millisecond, 100},
+		{"spike after", NewSpikeProfile(10, 100, time.Second, time.Second, time.Minute), 2 * time.Second, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.profile.Rate(tt.elapsed); got != tt.want {
+				t.Errorf("Rate(%v) = %v, want %v", tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPace(t *testing.T) {
+	const rate, duration = 1000, 300 * time.Millisecond
+	var started atomic.Int64
+	begin := time.Now()
+	pace(context.Background(), NewConstantProfile(rate, duration), func() { started.Add(1) })
+	if elapsed := time.Since(begin); elapsed < duration || elapsed > duration+100*time.Millisecond {
+		t.Errorf("pace returned after %v, want about %v", elapsed, duration)
+	}
+	want := rate * duration.Seconds()
+	if got := float64(started.Load()); math.Abs(got-want) > want*0.1 {
+		t.Errorf("pace started %v sessions, want about %v", got, want)
+	}
+}
+
+func TestPaceStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	begin := time.Now()
+	pace(ctx, NewConstantProfile(100, time.Minute), func() {})
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("pace returned %v after cancel, want at once", elapsed)
+	}
+}
+
+func TestPaceFollowsProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		window  time.Duration
+		want    []float64
+	}{
+		// 0/s rising to 2000/s: the second half starts three times as many.
+		{"ramp", NewRampProfile(0, 2000, 400*time.Millisecond), 200 * time.Millisecond, []float64{100, 300}},
+		{"step", NewStepProfile(500, 1500, 1000, 200*time.Millisecond, 400*time.Millisecond), 200 * time.Millisecond, []float64{100, 300}},
+		{"spike", NewSpikeProfile(250, 2000, 100*time.Millisecond, 100*time.Millisecond, 300*time.Millisecond), 100 * time.Millisecond, []float64{25, 200, 25}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]float64, len(tt.want))
+			begin := time.Now()
+			pace(context.Background(), tt.profile, func() {
+				if i := int(time.Since(begin) / tt.window); i < len(got) {
+					got[i]++
+				}
+			})
+			for i, want := range tt.want {
+				if math.Abs(got[i]-want) > math.Max(want*0.2, 10) {
+					t.Errorf("pace started %v sessions in %v..%v, want about %v",
+						got[i], time.Duration(i)*tt.window, time.Duration(i+1)*tt.window, want)
+				}
+			}
+		})
+	}
+}
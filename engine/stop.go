@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"load-test/config"
+	"load-test/report"
+)
+
+// The message limit is checked every limitCheckInterval,
+// the thresholds every stopCheckInterval.
+const (
+	limitCheckInterval = 100 * time.Millisecond
+	stopCheckInterval  = time.Second
+)
+
+// minWindowRequests is the number of requests the threshold window needs
+// before its rates are trusted, so a handful of early errors does not stop
+// a run.
+const minWindowRequests = 100
+
+// StopConditions end a run before its accounts or profile are used up.
+// Zero values disable a condition. Once Sessions sessions started no more
+// are, and the run ends when they are done; the other conditions stop the
+// run at once, draining its sessions. The thresholds are evaluated over the
+// requests of the last Window.
+type StopConditions struct {
+	Duration time.Duration
+	Sessions uint64
+	Messages uint64
+
+	MaxErrorRate   float64
+	MaxTimeoutRate float64
+	MaxP99         time.Duration
+	Window         time.Duration
+}
+
+func stopConditions(c config.Stop) StopConditions {
+	return StopConditions{
+		Duration:       c.Duration,
+		Sessions:       uint64(c.Sessions),
+		Messages:       uint64(c.Messages),
+		MaxErrorRate:   c.MaxErrorRate,
+		MaxTimeoutRate: c.MaxTimeoutRate,
+		MaxP99:         c.MaxP99,
+		Window:         c.Window,
+	}
+}
+
+// unbounded reports whether the run may go past the account range, which
+// is then cycled through until a condition stops it.
+func (c StopConditions) unbounded() bool {
+	return c.Duration > 0 || c.Sessions > 0
+}
+
+func (c StopConditions) thresholds() bool {
+	return c.MaxErrorRate > 0 || c.MaxTimeoutRate > 0 || c.MaxP99 > 0
+}
+
+// watch stops the run with the first condition that is met, until ctx is
+// done.
+func (c StopConditions) watch(ctx context.Context, stop func(cause error), recorder *report.Recorder) {
+	var timer <-chan time.Time
+	if c.Duration > 0 {
+		t := time.NewTimer(c.Duration)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	var ticks <-chan time.Time
+	if c.Messages > 0 || c.thresholds() {
+		ticker := time.NewTicker(limitCheckInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	sliding := newWindow(c.Window)
+	var checked time.Time
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer:
+			stop(fmt.Errorf("stop condition: duration %v reached", c.Duration))
+			return
+		case now = <-ticks:
+		}
+		var w *window
+		if now.Sub(checked) >= stopCheckInterval {
+			w, checked = sliding, now
+		}
+		if err := c.check(recorder, w); err != nil {
+			stop(err)
+			return
+		}
+	}
+}
+
+// check returns the condition that is met, if any. The thresholds are only
+// checked when w is set.
+func (c StopConditions) check(recorder *report.Recorder, w *window) error {
+	totals := recorder.Totals()
+	if c.Messages > 0 && totals.Sent >= c.Messages {
+		return fmt.Errorf("stop condition: %d messages sent", totals.Sent)
+	}
+	if !c.thresholds() || w == nil {
+		return nil
+	}
+
+	d := w.add(totals)
+	if d.Sent < minWindowRequests {
+		return nil
+	}
+	errorRate := float64(d.Errors) / float64(d.Sent)
+	timeoutRate := float64(d.Timeouts) / float64(d.Sent)
+	switch {
+	case c.MaxErrorRate > 0 && errorRate > c.MaxErrorRate:
+		return fmt.Errorf("abort threshold: error rate %.2f%% over the last %v exceeds %.2f%%",
+			errorRate*100, w.span(), c.MaxErrorRate*100)
+	case c.MaxTimeoutRate > 0 && timeoutRate > c.MaxTimeoutRate:
+		return fmt.Errorf("abort threshold: timeout rate %.2f%% over the last %v exceeds %.2f%%",
+			timeoutRate*100, w.span(), c.MaxTimeoutRate*100)
+	}
+	if c.MaxP99 > 0 && d.Latency.Count() >= minWindowRequests {
+		if p99 := d.Latency.Quantile(0.99); p99 > c.MaxP99 {
+			return fmt.Errorf("abort threshold: p99 latency %v over the last %v exceeds %v",
+				p99.Round(10*time.Microsecond), w.span(), c.MaxP99)
+		}
+	}
+	return nil
+}
+
+// window keeps the totals of the last checks so rates can be taken over
+// a sliding period instead of the whole run.
+type window struct {
+	size   int
+	totals []report.Totals
+	taken  []time.Time
+}
+
+func newWindow(d time.Duration) *window {
+	size := int(d / stopCheckInterval)
+	if size < 1 {
+		size = 1
+	}
+	return &window{size: size}
+}
+
+// add appends t and returns the requests recorded since the oldest totals
+// kept; the first call returns t itself.
+func (w *window) add(t report.Totals) report.Totals {
+	w.totals = append(w.totals, t)
+	w.taken = append(w.taken, time.Now())
+	if len(w.totals) > w.size+1 {
+		w.totals = w.totals[1:]
+		w.taken = w.taken[1:]
+	}
+	if len(w.totals) == 1 {
+		return t
+	}
+	oldest := w.totals[0]
+	return report.Totals{
+		Sent:     t.Sent - oldest.Sent,
+		Errors:   t.Errors - oldest.Errors,
+		Timeouts: t.Timeouts - oldest.Timeouts,
+		Latency:  t.Latency.Since(oldest.Latency),
+	}
+}
+
+// span is the period the last add covered.
+func (w *window) span() time.Duration {
+	if len(w.taken) < 2 {
+		return stopCheckInterval
+	}
+	return w.taken[len(w.taken)-1].Sub(w.taken[0]).Round(time.Second)
+}
@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"load-test/models"
+	"load-test/report"
+)
+
+// record adds n requests of latency to recorder; errs of them fail and
+// timeouts of them go unanswered.
+func record(recorder *report.Recorder, n, errs, timeouts int, latency time.Duration) {
+	for i := 0; i < n; i++ {
+		s := report.Sample{Type: models.DataUpdate, Latency: latency, Answered: true}
+		switch {
+		case i < errs:
+			s.Err = errors.New("5012")
+		case i < errs+timeouts:
+			s.Answered, s.Timeout, s.Err = false, true, errors.New("timeout")
+		}
+		recorder.Record(s)
+	}
+}
+
+func TestWindowAdd(t *testing.T) {
+	recorder := report.NewRecorder()
+	w := newWindow(2 * stopCheckInterval)
+
+	record(recorder, 10, 1, 0, time.Millisecond)
+	if d := w.add(recorder.Totals()); d.Sent != 10 || d.Errors != 1 {
+		t.Fatalf("first add = %d sent, %d errors, want the totals 10 and 1", d.Sent, d.Errors)
+	}
+	record(recorder, 20, 2, 3, time.Millisecond)
+	if d := w.add(recorder.Totals()); d.Sent != 20 || d.Errors != 2 || d.Timeouts != 3 {
+		t.Fatalf("second add = %d sent, %d errors, %d timeouts, want 20, 2, 3", d.Sent, d.Errors, d.Timeouts)
+	}
+	record(recorder, 30, 0, 0, time.Millisecond)
+	if d := w.add(recorder.Totals()); d.Sent != 50 || d.Errors != 2 || d.Latency.Count() != 47 {
+		t.Fatalf("third add = %d sent, %d errors, %d answered, want 50, 2, 47 over two intervals",
+			d.Sent, d.Errors, d.Latency.Count())
+	}
+	// The first 30 requests fell out of the window.
+	record(recorder, 5, 5, 0, time.Millisecond)
+	if d := w.add(recorder.Totals()); d.Sent != 35 || d.Errors != 5 {
+		t.Fatalf("fourth add = %d sent, %d errors, want 35 and 5", d.Sent, d.Errors)
+	}
+}
+
+func TestStopConditionsCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions StopConditions
+		requests   int
+		errs       int
+		timeouts   int
+		latency    time.Duration
+		want       string
+	}{
+		{name: "no conditions", requests: 1000, errs: 1000},
+		{name: "message limit", conditions: StopConditions{Messages: 100}, requests: 100, want: "stop condition: 100 messages sent"},
+		{name: "under message limit", conditions: StopConditions{Messages: 100}, requests: 99},
+		{name: "error rate", conditions: StopConditions{MaxErrorRate: 0.05}, requests: 200, errs: 20, want: "abort threshold: error rate 10.00%"},
+		{name: "error rate met", conditions: StopConditions{MaxErrorRate: 0.05}, requests: 200, errs: 10},
+		{name: "too few requests", conditions: StopConditions{MaxErrorRate: 0.05}, requests: minWindowRequests - 1, errs: 50},
+		{name: "timeout rate", conditions: StopConditions{MaxTimeoutRate: 0.01}, requests: 200, timeouts: 4, want: "abort threshold: timeout rate 2.00%"},
+		{name: "p99", conditions: StopConditions{MaxP99: 10 * time.Millisecond}, requests: 200, latency: 20 * time.Millisecond, want: "abort threshold: p99 latency"},
+		{name: "p99 met", conditions: StopConditions{MaxP99: 10 * time.Millisecond}, requests: 200, latency: 5 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := report.NewRecorder()
+			record(recorder, tt.requests, tt.errs, tt.timeouts, tt.latency)
+			err := tt.conditions.check(recorder, newWindow(time.Second))
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("check = %v, want no condition met", err)
+			case tt.want != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.want)):
+				t.Errorf("check = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStopConditionsThresholdsNeedWindow(t *testing.T) {
+	recorder := report.NewRecorder()
+	record(recorder, 200, 200, 0, time.Millisecond)
+	if err := (StopConditions{MaxErrorRate: 0.01}).check(recorder, nil); err != nil {
+		t.Errorf("check without a window = %v, want the thresholds skipped", err)
+	}
+}
+
+func TestWatchDuration(t *testing.T) {
+	var cause error
+	begin := time.Now()
+	StopConditions{Duration: 50 * time.Millisecond}.watch(context.Background(), func(err error) { cause = err }, report.NewRecorder())
+	if cause == nil || cause.Error() != "stop condition: duration 50ms reached" {
+		t.Fatalf("watch stopped with %v, want the duration", cause)
+	}
+	if elapsed := time.Since(begin); elapsed < 50*time.Millisecond {
+		t.Errorf("watch stopped after %v, want 50ms", elapsed)
+	}
+}
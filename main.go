@@ -1,18 +1,92 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
+	log "github.com/sirupsen/logrus"
+	"load-test/config"
 	"load-test/engine"
+	"load-test/report"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve-ocs" {
+		serveOCS(os.Args[2:])
+		return
+	}
+
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the load test args describe and returns the exit code: 1 when
+// an SLA assertion failed.
+func run(args []string) int {
 	start := time.Now()
-	numberOfAccounts := flag.Int("num", 1000000, "Number of accounts to create")
-	timeout := flag.Duration("timeout", 5*time.Second, "Number of accounts to create")
-	flag.Parse()
-	fmt.Printf("Number of accounts to create: %d\n", *numberOfAccounts)
-	engine.Start(*numberOfAccounts, *timeout)
+	cfg, err := config.Load(args)
+	if err != nil {
+		log.Fatalf("unable to load config: %v", err)
+	}
+
+	var profile engine.Profile
+	if cfg.Run.Profile != "" {
+		profile, err = engine.ParseProfile(cfg.Run.Profile)
+		if err != nil {
+			log.Fatalf("invalid -profile: %v", err)
+		}
+	}
+	mix, err := engine.BuildMix(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Run.EmbeddedOCS {
+		defer startEmbeddedOCS(cfg).Close()
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	go stopOnSignal(cancel)
+
+	fmt.Printf("Number of accounts to create: %d\n", cfg.Run.Accounts)
+	summary, err := engine.Start(ctx, cfg, mix, profile)
+	if err != nil {
+		log.Fatalf("unable to start the run: %v", err)
+	}
+	summary.Print(os.Stdout)
+	if cfg.Run.ReportJSON != "" {
+		if err := writeReport(cfg.Run.ReportJSON, summary); err != nil {
+			log.Errorf("write report err: %v", err)
+		}
+	}
 	fmt.Printf("Time elapsed: %v\n", time.Since(start))
+	if !summary.Passed() {
+		return 1
+	}
+	return 0
+}
+
+// stopOnSignal stops the run on the first SIGINT or SIGTERM so its sessions
+// are drained, and exits at once on the second.
+func stopOnSignal(cancel context.CancelCauseFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Warnf("%v received, draining sessions; send it again to exit at once", sig)
+	cancel(fmt.Errorf("signal %v", sig))
+	sig = <-signals
+	log.Errorf("%v received again, exiting without draining", sig)
+	os.Exit(1)
+}
+
+func writeReport(path string, summary *report.Summary) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return summary.WriteJSON(f)
 }
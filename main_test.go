@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestRunExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		assert string
+		want   int
+	}{
+		{"assertions pass", "timeouts == 0; never_terminated == 0", 0},
+		{"assertion fails", "timeouts == 0; timeouts > 0", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := []string{"-embedded-ocs", "-num", "5", "-dashboard", "0", "-assert", tt.assert}
+			if got := run(args); got != tt.want {
+				t.Errorf("run(%q) = %d, want %d", args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"load-test/models"
+)
+
+const namespace = "loadtest"
+
+// Metrics exposes the run to Prometheus. A nil *Metrics records nothing,
+// so callers need not check whether metrics are enabled.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	sent        *prometheus.CounterVec
+	resultCodes *prometheus.CounterVec
+	timeouts    *prometheus.CounterVec
+	errors      *prometheus.CounterVec
+	latency     *prometheus.HistogramVec
+	inFlight    prometheus.Gauge
+	flows       prometheus.Gauge
+	sessions    *prometheus.GaugeVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "ccr_sent_total",
+			Help: "CCRs sent, by message type.",
+		}, []string{"type"}),
+		resultCodes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "cca_result_codes_total",
+			Help: "CCAs received, by message type and Result-Code.",
+		}, []string{"type", "code"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "ccr_timeouts_total",
+			Help: "CCRs left without a CCA within the timeout, by message type.",
+		}, []string{"type"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "ccr_errors_total",
+			Help: "CCRs that failed, timeouts and error Result-Codes included, by message type.",
+		}, []string{"type"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "cca_latency_seconds",
+			Help:    "Time from a CCR to its CCA, by message type.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"type"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "ccr_in_flight",
+			Help: "CCRs sent and waiting for their CCA.",
+		}),
+		flows: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "flows_active",
+			Help: "Account session flows running.",
+		}),
+		sessions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "sessions_active",
+			Help: "Credit-control sessions opened by a CCR-I and not terminated yet, by service.",
+		}, []string{"service"}),
+	}
+	m.registry.MustRegister(
+		m.sent, m.resultCodes, m.timeouts, m.errors, m.latency, m.inFlight, m.flows, m.sessions,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Serve exposes /metrics on addr until the returned server is closed.
+func (m *Metrics) Serve(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to listen for metrics")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry}))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("metrics server: %v", err)
+		}
+	}()
+	log.Infof("serving metrics on http://%s/metrics", ln.Addr())
+	return srv, nil
+}
+
+// Sent counts a CCR going out; Done must follow once it is answered or
+// given up on.
+func (m *Metrics) Sent(t models.MessageType) {
+	if m == nil {
+		return
+	}
+	m.sent.WithLabelValues(t.String()).Inc()
+	m.inFlight.Inc()
+}
+
+// Done records the outcome of a CCR counted by Sent.
+func (m *Metrics) Done(r models.Result, timeout bool) {
+	if m == nil {
+		return
+	}
+	m.inFlight.Dec()
+	t := r.Type.String()
+	if timeout {
+		m.timeouts.WithLabelValues(t).Inc()
+	}
+	if r.Err != "" {
+		m.errors.WithLabelValues(t).Inc()
+	}
+	if !r.Received.IsZero() {
+		m.latency.WithLabelValues(t).Observe(r.Latency.Seconds())
+		if r.ResultCode != 0 {
+			m.resultCodes.WithLabelValues(t, strconv.FormatUint(uint64(r.ResultCode), 10)).Inc()
+		}
+	}
+}
+
+func (m *Metrics) FlowStarted() {
+	if m != nil {
+		m.flows.Inc()
+	}
+}
+
+func (m *Metrics) FlowEnded() {
+	if m != nil {
+		m.flows.Dec()
+	}
+}
+
+func (m *Metrics) SessionOpened(service models.Service) {
+	if m != nil {
+		m.sessions.WithLabelValues(string(service)).Inc()
+	}
+}
+
+func (m *Metrics) SessionClosed(service models.Service) {
+	if m != nil {
+		m.sessions.WithLabelValues(string(service)).Dec()
+	}
+}
+
+// WatchPeers exports the connections of each peer by state, as reported by
+// states at every scrape.
+func (m *Metrics) WatchPeers(states func() map[string]map[string]int) {
+	if m != nil {
+		m.registry.MustRegister(&peerCollector{states: states})
+	}
+}
+
+var peerConnectionsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "peer_connections"),
+	"Connections to each OCS peer, by watchdog state.",
+	[]string{"peer", "state"}, nil,
+)
+
+type peerCollector struct {
+	states func() map[string]map[string]int
+}
+
+func (c *peerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- peerConnectionsDesc
+}
+
+func (c *peerCollector) Collect(ch chan<- prometheus.Metric) {
+	for peer, states := range c.states() {
+		for state, n := range states {
+			ch <- prometheus.MustNewConstMetric(peerConnectionsDesc, prometheus.GaugeValue, float64(n), peer, state)
+		}
+	}
+}
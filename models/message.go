@@ -0,0 +1,52 @@
+package models
+
+type Service string
+
+const (
+	ServiceData         Service = "data"
+	ServiceVoiceCalling Service = "voice_calling"
+	ServiceVoiceCalled  Service = "voice_called"
+	ServiceVideoCalling Service = "video_calling"
+)
+
+type RequestType string
+
+const (
+	RequestInit      RequestType = "init"
+	RequestUpdate    RequestType = "update"
+	RequestTerminate RequestType = "terminate"
+)
+
+// MessageType identifies a CCR by the service it charges and its
+// CC-Request-Type, e.g. "data.update".
+type MessageType struct {
+	Service Service
+	Request RequestType
+}
+
+func (m MessageType) String() string {
+	return string(m.Service) + "." + string(m.Request)
+}
+
+var (
+	DataInit              = MessageType{ServiceData, RequestInit}
+	DataUpdate            = MessageType{ServiceData, RequestUpdate}
+	DataTerminate         = MessageType{ServiceData, RequestTerminate}
+	VoiceCallingInit      = MessageType{ServiceVoiceCalling, RequestInit}
+	VoiceCallingUpdate    = MessageType{ServiceVoiceCalling, RequestUpdate}
+	VoiceCallingTerminate = MessageType{ServiceVoiceCalling, RequestTerminate}
+	VoiceCalledInit       = MessageType{ServiceVoiceCalled, RequestInit}
+	VoiceCalledUpdate     = MessageType{ServiceVoiceCalled, RequestUpdate}
+	VoiceCalledTerminate  = MessageType{ServiceVoiceCalled, RequestTerminate}
+	VideoCallingInit      = MessageType{ServiceVideoCalling, RequestInit}
+	VideoCallingUpdate    = MessageType{ServiceVideoCalling, RequestUpdate}
+	VideoCallingTerminate = MessageType{ServiceVideoCalling, RequestTerminate}
+)
+
+// MessageTypes lists every CCR the client can send, in report order.
+var MessageTypes = []MessageType{
+	DataInit, DataUpdate, DataTerminate,
+	VoiceCallingInit, VoiceCallingUpdate, VoiceCallingTerminate,
+	VoiceCalledInit, VoiceCalledUpdate, VoiceCalledTerminate,
+	VideoCallingInit, VideoCallingUpdate, VideoCallingTerminate,
+}
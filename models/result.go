@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Result is the outcome of one CCR: what was sent and what the CCA, if any,
+// answered.
+type Result struct {
+	AccountID     AccountID
+	SessionID     string
+	Type          MessageType
+	RequestNumber uint32
+	Sent          time.Time
+	// Received is zero when no CCA arrived.
+	Received   time.Time
+	Latency    time.Duration
+	ResultCode uint32
+
+	// Granted units summed over the CCA's Multiple-Services-Credit-Control.
+	GrantedTime         uint32
+	GrantedTotalOctets  uint64
+	GrantedInputOctets  uint64
+	GrantedOutputOctets uint64
+
+	Err string
+}
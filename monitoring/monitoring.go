@@ -1,74 +1,226 @@
 package monitoring
 
-import "sync"
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
-var monit map[string]Monit
+	"load-test/models"
+	"load-test/report"
+)
+
+type State string
 
-type Monit struct {
-	AccountID   string
-	IsInit      bool
-	IsTerminate bool
-	UpdateCount int
-	MaxUpdate   int
+const (
+	// StateInit sessions have sent their CCR-I and wait for its answer.
+	StateInit State = "init"
+	// StateActive sessions were opened by the OCS and are not terminated yet.
+	StateActive State = "active"
+	// StateTerminated sessions got a successful answer to their CCR-T.
+	StateTerminated State = "terminated"
+	// StateFailed sessions had their CCR-I or CCR-T fail. A CCR-I that
+	// timed out leaves its session in init: the OCS may have opened it and
+	// the flow may go on with it.
+	StateFailed State = "failed"
+)
 
-	mu sync.Mutex
+type session struct {
+	accountID models.AccountID
+	state     State
+	started   time.Time
+	// last is when the session last sent a request.
+	last time.Time
 }
 
-type State struct {
-	AccountID string
-	Type      string
+// Tracker follows every session from its CCR-I to its CCR-T. Only open
+// sessions are kept; ended ones are just counted.
+type Tracker struct {
+	start time.Time
+	// staleAfter is how long an open session may go without a request
+	// before it counts as stale.
+	staleAfter time.Duration
+
+	mu         sync.Mutex
+	open       map[string]*session
+	terminated uint64
+	failed     uint64
+
+	// Counters since the last dashboard line.
+	sent     atomic.Uint64
+	answered atomic.Uint64
+	timeouts atomic.Uint64
+	errors   atomic.Uint64
+	latency  atomic.Int64
 }
 
-type StateType string
+func NewTracker(staleAfter time.Duration) *Tracker {
+	return &Tracker{
+		start:      time.Now(),
+		staleAfter: staleAfter,
+		open:       make(map[string]*session),
+	}
+}
 
-const (
-	StateTypeInit      = "init"
-	StateTypeTerminate = "terminate"
-	StateTypeUpdate    = "update"
-)
+// Sent notes a request of the session going out.
+func (t *Tracker) Sent(accountID models.AccountID, sessionID string, messageType models.MessageType) {
+	t.sent.Add(1)
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.open[sessionID]
+	if !ok {
+		if messageType.Request != models.RequestInit {
+			// The session already ended, e.g. a CCR-T after a failed one.
+			return
+		}
+		s = &session{accountID: accountID, state: StateInit, started: now}
+		t.open[sessionID] = s
+	}
+	s.last = now
+}
 
-var Monitoring chan State
+// Done notes the outcome of a request sent with Sent.
+func (t *Tracker) Done(r models.Result, timeout bool) {
+	switch {
+	case timeout:
+		t.timeouts.Add(1)
+	case !r.Received.IsZero():
+		t.answered.Add(1)
+		t.latency.Add(int64(r.Latency))
+	}
+	failed := r.Err != ""
+	if failed && !timeout {
+		t.errors.Add(1)
+	}
 
-func Init(numberOfAccounts int) func() {
-	Monitoring = make(chan State, numberOfAccounts)
-	for i := 0; i < 10; i++ {
-		go monitoringWorker()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.open[r.SessionID]
+	if !ok {
+		return
+	}
+	switch r.Type.Request {
+	case models.RequestInit:
+		switch {
+		case !failed:
+			s.state = StateActive
+		case !timeout:
+			delete(t.open, r.SessionID)
+			t.failed++
+		}
+	case models.RequestUpdate:
+		if !failed {
+			// Answered after its CCR-I timed out.
+			s.state = StateActive
+		}
+	case models.RequestTerminate:
+		delete(t.open, r.SessionID)
+		if failed {
+			t.failed++
+		} else {
+			t.terminated++
+		}
 	}
-	return closer
 }
 
-func closer() {
-	close(Monitoring)
+// Counts are the sessions in each state; Stale is how many of the open ones
+// sent nothing for the stale period.
+type Counts struct {
+	Init       int
+	Active     int
+	Terminated uint64
+	Failed     uint64
+	Stale      int
 }
 
-func monitoringWorker() {
-	for state := range Monitoring {
-		handler(state)
+func (t *Tracker) Counts() Counts {
+	stale := time.Now().Add(-t.staleAfter)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := Counts{Terminated: t.terminated, Failed: t.failed}
+	for _, s := range t.open {
+		if s.state == StateInit {
+			c.Init++
+		} else {
+			c.Active++
+		}
+		if t.staleAfter > 0 && s.last.Before(stale) {
+			c.Stale++
+		}
 	}
+	return c
 }
 
-func handler(s State) {
-	if m, ok := monit[s.AccountID]; ok {
-		m.mu.Lock()
-		defer m.mu.Unlock()
-
-		if s.Type == StateTypeUpdate {
-			m.UpdateCount += 1
-		} else if s.Type == StateTypeTerminate {
-			m.IsTerminate = true
-		} else if s.Type == StateTypeInit {
-			panic("why we are here???")
+// SessionStates sums up the tracked sessions for the report. Sessions
+// still open are the ones that never terminated; the oldest samples of them
+// are listed.
+func (t *Tracker) SessionStates(samples int) report.SessionStates {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	states := report.SessionStates{Terminated: t.terminated, Failed: t.failed}
+	open := make([]report.OpenSession, 0, len(t.open))
+	for id, s := range t.open {
+		if s.state == StateInit {
+			states.Init++
 		} else {
-			panic("woooooow we should not be here")
+			states.Active++
 		}
-	} else {
-		monit[s.AccountID] = Monit{
-			AccountID:   s.AccountID,
-			IsInit:      true,
-			IsTerminate: false,
-			UpdateCount: 0,
-			MaxUpdate:   0,
-			mu:          sync.Mutex{},
+		open = append(open, report.OpenSession{
+			AccountID: s.accountID.String(),
+			SessionID: id,
+			State:     string(s.state),
+			Age:       now.Sub(s.started),
+			Idle:      now.Sub(s.last),
+		})
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].Age > open[j].Age })
+	if len(open) > samples {
+		open = open[:samples]
+	}
+	states.NeverTerminated = open
+	return states
+}
+
+// Dashboard prints a line of live figures to w every interval until stop
+// is called.
+func (t *Tracker) Dashboard(w io.Writer, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				t.printLine(w, now.Sub(last))
+				last = now
+			}
 		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func (t *Tracker) printLine(w io.Writer, period time.Duration) {
+	sent := t.sent.Swap(0)
+	answered := t.answered.Swap(0)
+	latency := time.Duration(t.latency.Swap(0))
+	var avg time.Duration
+	if answered > 0 {
+		avg = (latency / time.Duration(answered)).Round(10 * time.Microsecond)
 	}
+	c := t.Counts()
+	fmt.Fprintf(w, "[%6v] sessions init %d active %d terminated %d failed %d stale %d | %.0f req/s, %d timeouts, %d errors, avg %v\n",
+		time.Since(t.start).Round(time.Second), c.Init, c.Active, c.Terminated, c.Failed, c.Stale,
+		float64(sent)/period.Seconds(), t.timeouts.Swap(0), t.errors.Swap(0), avg)
 }
@@ -0,0 +1,51 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"load-test/models"
+)
+
+// outcome is a request of a session and how it went.
+type outcome struct {
+	mt      models.MessageType
+	err     string
+	timeout bool
+}
+
+func TestTrackerStates(t *testing.T) {
+	ok := func(mt models.MessageType) outcome { return outcome{mt: mt} }
+	timedOut := func(mt models.MessageType) outcome { return outcome{mt: mt, err: "timeout", timeout: true} }
+	refused := func(mt models.MessageType) outcome { return outcome{mt: mt, err: "5030"} }
+
+	for _, test := range []struct {
+		name     string
+		outcomes []outcome
+		want     Counts
+	}{
+		{"waiting for CCA-I", nil, Counts{Init: 1}},
+		{"opened", []outcome{ok(models.DataInit)}, Counts{Active: 1}},
+		{"terminated", []outcome{ok(models.DataInit), ok(models.DataUpdate), ok(models.DataTerminate)}, Counts{Terminated: 1}},
+		{"init refused", []outcome{refused(models.DataInit)}, Counts{Failed: 1}},
+		{"terminate refused", []outcome{ok(models.DataInit), refused(models.DataTerminate)}, Counts{Failed: 1}},
+		{"init timed out", []outcome{timedOut(models.DataInit)}, Counts{Init: 1}},
+		{"updated after init timed out", []outcome{timedOut(models.DataInit), ok(models.DataUpdate)}, Counts{Active: 1}},
+		{"terminated after init timed out", []outcome{timedOut(models.DataInit), ok(models.DataTerminate)}, Counts{Terminated: 1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := NewTracker(time.Hour)
+			account := models.NewAccountID(1)
+			tracker.Sent(account, "s1", models.DataInit)
+			for _, o := range test.outcomes {
+				if o.mt.Request != models.RequestInit {
+					tracker.Sent(account, "s1", o.mt)
+				}
+				tracker.Done(models.Result{AccountID: account, SessionID: "s1", Type: o.mt, Err: o.err}, o.timeout)
+			}
+			if got := tracker.Counts(); got != test.want {
+				t.Errorf("Counts() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package ocs
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Latency draws the artificial delay applied before each answer.
+type Latency interface {
+	Sample() time.Duration
+	String() string
+}
+
+type fixedLatency time.Duration
+
+func (l fixedLatency) Sample() time.Duration { return time.Duration(l) }
+func (l fixedLatency) String() string        { return fmt.Sprintf("fixed %v", time.Duration(l)) }
+
+type uniformLatency struct{ min, max time.Duration }
+
+func (l uniformLatency) Sample() time.Duration {
+	if l.max <= l.min {
+		return l.min
+	}
+	return l.min + time.Duration(rand.Int63n(int64(l.max-l.min)))
+}
+func (l uniformLatency) String() string { return fmt.Sprintf("uniform %v-%v", l.min, l.max) }
+
+type normalLatency struct{ mean, stddev time.Duration }
+
+func (l normalLatency) Sample() time.Duration {
+	d := time.Duration(rand.NormFloat64()*float64(l.stddev)) + l.mean
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+func (l normalLatency) String() string {
+	return fmt.Sprintf("normal mean %v stddev %v", l.mean, l.stddev)
+}
+
+type expLatency struct{ mean time.Duration }
+
+func (l expLatency) Sample() time.Duration {
+	return time.Duration(math.Round(rand.ExpFloat64() * float64(l.mean)))
+}
+func (l expLatency) String() string { return fmt.Sprintf("exponential mean %v", l.mean) }
+
+// ParseLatency parses a latency spec:
+//
+//	5ms | fixed:5ms
+//	uniform:1ms,10ms
+//	normal:5ms,2ms     (mean, stddev)
+//	exp:5ms            (mean)
+func ParseLatency(spec string) (Latency, error) {
+	if spec == "" {
+		return fixedLatency(0), nil
+	}
+	kind, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		kind, rest = "fixed", spec
+	}
+	var ds []time.Duration
+	for _, s := range strings.Split(rest, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s latency", kind)
+		}
+		ds = append(ds, d)
+	}
+
+	want := map[string]int{"fixed": 1, "uniform": 2, "normal": 2, "exp": 1}
+	n, known := want[kind]
+	if !known {
+		return nil, fmt.Errorf("unknown latency distribution %q", kind)
+	}
+	if len(ds) != n {
+		return nil, fmt.Errorf("%s latency takes %d durations, got %d", kind, n, len(ds))
+	}
+	switch kind {
+	case "uniform":
+		return uniformLatency{min: ds[0], max: ds[1]}, nil
+	case "normal":
+		return normalLatency{mean: ds[0], stddev: ds[1]}, nil
+	case "exp":
+		return expLatency{mean: ds[0]}, nil
+	default:
+		return fixedLatency(ds[0]), nil
+	}
+}
@@ -0,0 +1,506 @@
+package ocs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MHG14/go-diameter/v4/diam"
+	"github.com/MHG14/go-diameter/v4/diam/avp"
+	"github.com/MHG14/go-diameter/v4/diam/datatype"
+	"github.com/MHG14/go-diameter/v4/diam/dict"
+	"github.com/MHG14/go-diameter/v4/diam/sm"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	requestTypeInitial   = 1
+	requestTypeTerminate = 3
+	creditLimitReached   = 4012
+)
+
+// Settings controls how the fake OCS answers.
+type Settings struct {
+	// Network is tcp, sctp or tls; tls needs CertFile and KeyFile.
+	Network     string
+	Addr        string
+	OriginHost  string
+	OriginRealm string
+	CertFile    string
+	KeyFile     string
+
+	// ResultCode is sent in every CCA that is not turned into an error.
+	ResultCode    uint32
+	GrantedOctets uint64
+	GrantedTime   uint32
+	ValidityTime  uint32
+	Latency       Latency
+
+	// ErrorRate is the fraction of CCRs answered with ErrorCode instead.
+	ErrorRate float64
+	ErrorCode uint32
+	// DropRate is the fraction of CCRs that are never answered.
+	DropRate float64
+
+	// From CC-Request-Number FinalUnitAfter on (0: never), grants carry a
+	// Final-Unit-Indication with FinalUnitAction: terminate, redirect or
+	// restrict.
+	FinalUnitAfter  uint32
+	FinalUnitAction string
+	RedirectAddress string
+	// From CC-Request-Number CreditLimitAfter on (0: never), updates are
+	// answered with 4012 DIAMETER_CREDIT_LIMIT_REACHED.
+	CreditLimitAfter uint32
+	// ReAuthAfter is how long after a session's CCR-I it is sent a
+	// Re-Auth-Request (0: never).
+	ReAuthAfter time.Duration
+	// AbortAfter is how long after a session's CCR-I it is torn down with
+	// an Abort-Session-Request (0: never).
+	AbortAfter time.Duration
+
+	// KeepRequests journals every CCR received, for Requests.
+	KeepRequests bool
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		Network:       "tcp",
+		Addr:          ":3868",
+		OriginHost:    "ocs.load-test",
+		OriginRealm:   "load-test",
+		ResultCode:    2001,
+		GrantedOctets: 10 * 1024 * 1024,
+		GrantedTime:   600,
+		ValidityTime:  3600,
+		Latency:       fixedLatency(0),
+		ErrorCode:     5012,
+
+		FinalUnitAction: "terminate",
+		RedirectAddress: "http://topup.load-test/",
+	}
+}
+
+var finalUnitActions = map[string]uint32{"terminate": 0, "redirect": 1, "restrict": 2}
+
+type Stats struct {
+	Received uint64
+	Answered uint64
+	Errors   uint64
+	Dropped  uint64
+	ReAuths  uint64
+	Aborts   uint64
+	// ReAuthAnswers and AbortAnswers count the RAAs and ASAs received.
+	ReAuthAnswers uint64
+	AbortAnswers  uint64
+}
+
+// Request is a CCR as the server received it, journaled when
+// Settings.KeepRequests is set.
+type Request struct {
+	SessionID        string
+	Type             uint32
+	Number           uint32
+	TerminationCause uint32
+	// Used holds the Used-Service-Unit of every MSCC that carried one.
+	Used     []UsedUnits
+	Received time.Time
+}
+
+// UsedUnits is one Used-Service-Unit with the Reporting-Reason sent in it
+// or next to it in its MSCC.
+type UsedUnits struct {
+	Time            uint32
+	InputOctets     uint64
+	OutputOctets    uint64
+	ReportingReason uint32
+}
+
+// Server is a minimal Diameter Credit-Control server. The embedded state
+// machine completes CER/CEA and DWR/DWA; CCRs are answered per Settings.
+type Server struct {
+	settings Settings
+	mux      *sm.StateMachine
+
+	received atomic.Uint64
+	answered atomic.Uint64
+	errors   atomic.Uint64
+	dropped  atomic.Uint64
+	reauths  atomic.Uint64
+	aborts   atomic.Uint64
+	raas     atomic.Uint64
+	asas     atomic.Uint64
+
+	mu       sync.Mutex
+	listener net.Listener
+	requests []Request
+}
+
+func New(settings Settings) (*Server, error) {
+	if settings.Latency == nil {
+		settings.Latency = fixedLatency(0)
+	}
+	if _, ok := finalUnitActions[settings.FinalUnitAction]; !ok {
+		return nil, fmt.Errorf("unknown final unit action %q", settings.FinalUnitAction)
+	}
+	s := &Server{settings: settings}
+	s.mux = sm.New(&sm.Settings{
+		OriginHost:       datatype.DiameterIdentity(settings.OriginHost),
+		OriginRealm:      datatype.DiameterIdentity(settings.OriginRealm),
+		VendorID:         0,
+		ProductName:      "load-test-ocs",
+		FirmwareRevision: 1,
+	})
+	s.mux.Handle("CCR", s.handleCCR())
+	s.mux.Handle("RAA", s.handleAnswer(&s.raas))
+	s.mux.Handle("ASA", s.handleAnswer(&s.asas))
+	s.mux.Handle("DPR", s.handleDPR())
+	go s.logErrors()
+	return s, nil
+}
+
+// Listen binds the configured address; the bound address is returned so
+// callers can use ":0".
+func (s *Server) Listen() (net.Addr, error) {
+	network := s.settings.Network
+	var config *tls.Config
+	if network == "tls" {
+		cert, err := tls.LoadX509KeyPair(s.settings.CertFile, s.settings.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		network = "tcp"
+		config = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	l, err := diam.MultistreamListen(network, s.settings.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		l = tls.NewListener(l, config)
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	return l.Addr(), nil
+}
+
+// Serve accepts peers until Close is called. Listen must be called first.
+func (s *Server) Serve() error {
+	s.mu.Lock()
+	l := s.listener
+	s.mu.Unlock()
+	srv := &diam.Server{
+		Network: s.settings.Network,
+		Addr:    s.settings.Addr,
+		Handler: s.mux,
+		Dict:    dict.Default,
+	}
+	return srv.Serve(l)
+}
+
+func (s *Server) ListenAndServe() error {
+	if _, err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
+
+func (s *Server) Stats() Stats {
+	return Stats{
+		Received: s.received.Load(),
+		Answered: s.answered.Load(),
+		Errors:   s.errors.Load(),
+		Dropped:  s.dropped.Load(),
+		ReAuths:  s.reauths.Load(),
+		Aborts:   s.aborts.Load(),
+
+		ReAuthAnswers: s.raas.Load(),
+		AbortAnswers:  s.asas.Load(),
+	}
+}
+
+// Requests returns the CCRs received so far in arrival order; it is empty
+// unless Settings.KeepRequests is set.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) logErrors() {
+	for err := range s.mux.ErrorReports() {
+		log.Debugf("ocs: %v", err)
+	}
+}
+
+type ccr struct {
+	SessionID     string `avp:"Session-Id"`
+	OriginHost    string `avp:"Origin-Host"`
+	OriginRealm   string `avp:"Origin-Realm"`
+	RequestType   uint32 `avp:"CC-Request-Type"`
+	RequestNumber uint32 `avp:"CC-Request-Number"`
+	MSCC          []struct {
+		RatingGroup       uint32 `avp:"Rating-Group"`
+		ServiceIdentifier uint32 `avp:"Service-Identifier"`
+	} `avp:"Multiple-Services-Credit-Control"`
+}
+
+func (s *Server) handleCCR() diam.HandlerFunc {
+	return func(c diam.Conn, m *diam.Message) {
+		s.received.Add(1)
+		req := ccr{}
+		if err := m.Unmarshal(&req); err != nil {
+			log.Errorf("ocs: unable to decode CCR: %v", err)
+			s.answer(c, m.Answer(diam.UnableToComply), 0)
+			s.errors.Add(1)
+			return
+		}
+		if s.settings.KeepRequests {
+			s.keep(m, &req)
+		}
+
+		if rand.Float64() < s.settings.DropRate {
+			s.dropped.Add(1)
+			return
+		}
+		code := s.settings.ResultCode
+		if after := s.settings.CreditLimitAfter; after > 0 && req.RequestNumber >= after && req.RequestType != requestTypeTerminate {
+			code = creditLimitReached
+		}
+		if rand.Float64() < s.settings.ErrorRate {
+			code = s.settings.ErrorCode
+			s.errors.Add(1)
+		}
+
+		s.answer(c, s.buildCCA(m, &req, code), s.settings.Latency.Sample())
+		if req.RequestType == requestTypeInitial {
+			if after := s.settings.ReAuthAfter; after > 0 {
+				time.AfterFunc(after, func() { s.request(c, diam.ReAuth, &req, &s.reauths) })
+			}
+			if after := s.settings.AbortAfter; after > 0 {
+				time.AfterFunc(after, func() { s.request(c, diam.AbortSession, &req, &s.aborts) })
+			}
+		}
+	}
+}
+
+// request sends a server-initiated RAR or ASR for the session req opened
+// and counts it in sent.
+func (s *Server) request(c diam.Conn, command uint32, req *ccr, sent *atomic.Uint64) {
+	m := diam.NewRequest(command, 4, dict.Default)
+	m.NewAVP(avp.SessionID, avp.Mbit, 0, datatype.UTF8String(req.SessionID))
+	m.NewAVP(avp.OriginHost, avp.Mbit, 0, datatype.DiameterIdentity(s.settings.OriginHost))
+	m.NewAVP(avp.OriginRealm, avp.Mbit, 0, datatype.DiameterIdentity(s.settings.OriginRealm))
+	m.NewAVP(avp.DestinationRealm, avp.Mbit, 0, datatype.DiameterIdentity(req.OriginRealm))
+	m.NewAVP(avp.DestinationHost, avp.Mbit, 0, datatype.DiameterIdentity(req.OriginHost))
+	m.NewAVP(avp.AuthApplicationID, avp.Mbit, 0, datatype.Unsigned32(4))
+	if command == diam.ReAuth {
+		// Re-Auth-Request-Type 0: AUTHORIZE_ONLY
+		m.NewAVP(avp.ReAuthRequestType, avp.Mbit, 0, datatype.Enumerated(0))
+	}
+	if _, err := m.WriteTo(c); err != nil {
+		log.Errorf("ocs: unable to write request %d: %v", command, err)
+		return
+	}
+	sent.Add(1)
+}
+
+// handleDPR answers a DPR; the peer closes the connection once it has the
+// DPA.
+func (s *Server) handleDPR() diam.HandlerFunc {
+	return func(c diam.Conn, m *diam.Message) {
+		a := m.Answer(diam.Success)
+		a.NewAVP(avp.OriginHost, avp.Mbit, 0, datatype.DiameterIdentity(s.settings.OriginHost))
+		a.NewAVP(avp.OriginRealm, avp.Mbit, 0, datatype.DiameterIdentity(s.settings.OriginRealm))
+		if _, err := a.WriteTo(c); err != nil {
+			log.Errorf("ocs: unable to write DPA: %v", err)
+		}
+	}
+}
+
+// handleAnswer counts the answers to the server's own requests in received.
+func (s *Server) handleAnswer(received *atomic.Uint64) diam.HandlerFunc {
+	return func(_ diam.Conn, m *diam.Message) {
+		received.Add(1)
+		log.Debugf("ocs: %s", m)
+	}
+}
+
+// keep journals the CCR m, decoded into req.
+func (s *Server) keep(m *diam.Message, req *ccr) {
+	r := Request{
+		SessionID: req.SessionID,
+		Type:      req.RequestType,
+		Number:    req.RequestNumber,
+		Received:  time.Now(),
+	}
+	for _, a := range m.AVP {
+		switch a.Code {
+		case avp.TerminationCause:
+			r.TerminationCause = uint32(unsigned(a))
+		case avp.MultipleServicesCreditControl:
+			if used, ok := usedUnits(a); ok {
+				r.Used = append(r.Used, used)
+			}
+		}
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	s.mu.Unlock()
+}
+
+// usedUnits decodes the Used-Service-Unit of the MSCC a.
+func usedUnits(mscc *diam.AVP) (used UsedUnits, ok bool) {
+	reason := func(a *diam.AVP) {
+		if a.Code == avp.ReportingReason {
+			used.ReportingReason = uint32(unsigned(a))
+		}
+	}
+	for _, a := range grouped(mscc) {
+		reason(a)
+		if a.Code != avp.UsedServiceUnit {
+			continue
+		}
+		ok = true
+		for _, u := range grouped(a) {
+			reason(u)
+			switch u.Code {
+			case avp.CCTime:
+				used.Time = uint32(unsigned(u))
+			case avp.CCInputOctets:
+				used.InputOctets = unsigned(u)
+			case avp.CCOutputOctets:
+				used.OutputOctets = unsigned(u)
+			}
+		}
+	}
+	return used, ok
+}
+
+func grouped(a *diam.AVP) []*diam.AVP {
+	if g, ok := a.Data.(*diam.GroupedAVP); ok {
+		return g.AVP
+	}
+	return nil
+}
+
+// unsigned decodes an integer AVP, also one the dictionary does not know.
+func unsigned(a *diam.AVP) uint64 {
+	switch v := a.Data.(type) {
+	case datatype.Unsigned32:
+		return uint64(v)
+	case datatype.Unsigned64:
+		return uint64(v)
+	case datatype.Enumerated:
+		return uint64(v)
+	case datatype.Integer32:
+		return uint64(v)
+	case datatype.Unknown:
+		var n uint64
+		for _, b := range v {
+			n = n<<8 | uint64(b)
+		}
+		return n
+	}
+	return 0
+}
+
+func (s *Server) answer(c diam.Conn, a *diam.Message, delay time.Duration) {
+	write := func() {
+		if _, err := a.WriteTo(c); err != nil {
+			log.Errorf("ocs: unable to write CCA: %v", err)
+			return
+		}
+		s.answered.Add(1)
+	}
+	if delay <= 0 {
+		write()
+		return
+	}
+	time.AfterFunc(delay, write)
+}
+
+func (s *Server) buildCCA(m *diam.Message, req *ccr, code uint32) *diam.Message {
+	a := m.Answer(code)
+	a.NewAVP(avp.SessionID, avp.Mbit, 0, datatype.UTF8String(req.SessionID))
+	a.NewAVP(avp.OriginHost, avp.Mbit, 0, datatype.DiameterIdentity(s.settings.OriginHost))
+	a.NewAVP(avp.OriginRealm, avp.Mbit, 0, datatype.DiameterIdentity(s.settings.OriginRealm))
+	a.NewAVP(avp.AuthApplicationID, avp.Mbit, 0, datatype.Unsigned32(4))
+	a.NewAVP(avp.CCRequestType, avp.Mbit, 0, datatype.Enumerated(req.RequestType))
+	a.NewAVP(avp.CCRequestNumber, avp.Mbit, 0, datatype.Unsigned32(req.RequestNumber))
+
+	if code < 2000 || code >= 3000 || req.RequestType == requestTypeTerminate {
+		return a
+	}
+
+	ratingGroups := []uint32{0}
+	if len(req.MSCC) > 0 {
+		ratingGroups = ratingGroups[:0]
+		for _, mscc := range req.MSCC {
+			ratingGroups = append(ratingGroups, mscc.RatingGroup)
+		}
+	}
+	final := s.settings.FinalUnitAfter > 0 && req.RequestNumber >= s.settings.FinalUnitAfter
+	for _, rg := range ratingGroups {
+		a.NewAVP(avp.MultipleServicesCreditControl, avp.Mbit, 0, s.grant(rg, final))
+	}
+	return a
+}
+
+func (s *Server) grant(ratingGroup uint32, final bool) *diam.GroupedAVP {
+	gsu := &diam.GroupedAVP{}
+	if s.settings.GrantedOctets > 0 {
+		gsu.AddAVP(diam.NewAVP(avp.CCTotalOctets, avp.Mbit, 0, datatype.Unsigned64(s.settings.GrantedOctets)))
+	}
+	if s.settings.GrantedTime > 0 {
+		gsu.AddAVP(diam.NewAVP(avp.CCTime, avp.Mbit, 0, datatype.Unsigned32(s.settings.GrantedTime)))
+	}
+
+	mscc := &diam.GroupedAVP{
+		AVP: []*diam.AVP{
+			diam.NewAVP(avp.GrantedServiceUnit, avp.Mbit, 0, gsu),
+			diam.NewAVP(avp.ResultCode, avp.Mbit, 0, datatype.Unsigned32(diam.Success)),
+		},
+	}
+	if ratingGroup != 0 {
+		mscc.AddAVP(diam.NewAVP(avp.RatingGroup, avp.Mbit, 0, datatype.Unsigned32(ratingGroup)))
+	}
+	if s.settings.ValidityTime > 0 {
+		mscc.AddAVP(diam.NewAVP(avp.ValidityTime, avp.Mbit, 0, datatype.Unsigned32(s.settings.ValidityTime)))
+	}
+	if final {
+		mscc.AddAVP(diam.NewAVP(avp.FinalUnitIndication, avp.Mbit, 0, s.finalUnit()))
+	}
+	return mscc
+}
+
+func (s *Server) finalUnit() *diam.GroupedAVP {
+	action := finalUnitActions[s.settings.FinalUnitAction]
+	fui := &diam.GroupedAVP{
+		AVP: []*diam.AVP{
+			diam.NewAVP(avp.FinalUnitAction, avp.Mbit, 0, datatype.Enumerated(action)),
+		},
+	}
+	if action == finalUnitActions["redirect"] {
+		fui.AddAVP(diam.NewAVP(avp.RedirectServer, avp.Mbit, 0, &diam.GroupedAVP{
+			AVP: []*diam.AVP{
+				// Redirect-Address-Type 2: URL
+				diam.NewAVP(avp.RedirectAddressType, avp.Mbit, 0, datatype.Enumerated(2)),
+				diam.NewAVP(avp.RedirectServerAddress, avp.Mbit, 0, datatype.UTF8String(s.settings.RedirectAddress)),
+			},
+		}))
+	}
+	return fui
+}
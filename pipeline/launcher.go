@@ -5,7 +5,10 @@ import (
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	"load-test/diameter"
+	"load-test/metrics"
 	"load-test/models"
+	"load-test/report"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -14,133 +17,480 @@ type Launcher interface {
 	Run()
 }
 
+// sessionCodes is the service tag embedded in each Session-Id.
+var sessionCodes = map[models.Service]int{
+	models.ServiceData:         10,
+	models.ServiceVoiceCalling: 20,
+	models.ServiceVoiceCalled:  21,
+	models.ServiceVideoCalling: 20,
+}
+
+type outcome struct {
+	answer *diameter.Answer
+	err    error
+}
+
 type account struct {
-	updateIteration     int
-	sleepTimes          time.Duration
-	client              diameter.Client
-	accountID           models.AccountID
-	otherID             models.AccountID
-	sessionData         string
-	sessionVoiceCalling string
-	sessionVoiceCalled  string
-	sessionVideoCalling string
-	sessionVideoCalled  string
+	scenario       *Scenario
+	abortOnTimeout bool
+	client         diameter.Client
+	recorder       *report.Recorder
+	metrics        *metrics.Metrics
+	accountID      models.AccountID
+	otherID        models.AccountID
+	// stop is closed when the run is stopped: the flow ends and the
+	// sessions it opened are terminated.
+	stop <-chan struct{}
+
+	// Parallel branches share the account's sessions and outcomes.
+	mu       sync.Mutex
+	sessions map[models.Service]*diameter.Session
+	ended    map[models.Service]bool
+	// active holds the services whose session the OCS opened and that
+	// were not terminated yet.
+	active   map[models.Service]bool
+	outcomes map[models.Service]outcome
+	last     outcome
+	// sending serializes the requests of each service's session, so a
+	// CCR-U asked for by a RAR never shares a sequence with the flow's own.
+	sending map[models.Service]*sync.Mutex
+	// reauths and aborts deliver the RARs and ASRs of the account's
+	// sessions.
+	reauths chan diameter.ReAuth
+	aborts  chan *diameter.Session
 }
 
 func NewAccount(
-	updateIteration int,
+	scenario *Scenario,
 	numberOfAccounts int,
-	sleepTimes time.Duration,
 	client diameter.Client,
+	recorder *report.Recorder,
+	metrics *metrics.Metrics,
 	accountID models.AccountID,
+	abortOnTimeout bool,
+	stop <-chan struct{},
 ) Launcher {
 	return &account{
-		updateIteration: updateIteration,
-		sleepTimes:      sleepTimes,
-		client:          client,
-		accountID:       accountID,
-		otherID:         accountID.Other(numberOfAccounts),
+		scenario:       scenario,
+		abortOnTimeout: abortOnTimeout,
+		client:         client,
+		recorder:       recorder,
+		metrics:        metrics,
+		accountID:      accountID,
+		otherID:        accountID.Other(numberOfAccounts),
+		stop:           stop,
+		sessions:       make(map[models.Service]*diameter.Session),
+		ended:          make(map[models.Service]bool),
+		active:         make(map[models.Service]bool),
+		outcomes:       make(map[models.Service]outcome),
+		sending:        make(map[models.Service]*sync.Mutex),
+		reauths:        make(chan diameter.ReAuth, len(sessionCodes)),
+		aborts:         make(chan *diameter.Session, len(sessionCodes)),
+	}
+}
+
+// failed logs err and reports whether the session flow should stop.
+// Timeouts only stop it when abortOnTimeout is set.
+func (m *account) failed(step string, err error) bool {
+	if err == nil {
+		return false
+	}
+	if diameter.IsTimeout(err) && !m.abortOnTimeout {
+		log.Warnf("%s timeout: %v", step, err)
+		return false
 	}
+	log.Errorf("%s err: %v", step, err)
+	return true
 }
 
 func (m *account) Run() {
-	wg := new(sync.WaitGroup)
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		m.sessionData = fmt.Sprintf("%s:10:%s", m.accountID, uuid.New().String())
-		err := m.client.InitData(m.accountID, m.sessionData)
-		if err != nil {
-			log.Errorf("init data err: %v", err)
-			return
+	m.metrics.FlowStarted()
+	m.run(m.scenario.Steps)
+	if m.stopping() {
+		m.drain()
+	}
+
+	// Sessions the flow gave up on no longer count as active.
+	m.mu.Lock()
+	for service := range m.active {
+		m.metrics.SessionClosed(service)
+	}
+	m.mu.Unlock()
+	m.metrics.FlowEnded()
+}
+
+// run executes steps in order and reports whether the flow may go on.
+func (m *account) run(steps []Step) bool {
+	for _, step := range steps {
+		if !m.step(step) {
+			return false
 		}
+	}
+	return true
+}
 
-		//time.Sleep(10 * time.Second)
+func (m *account) step(step Step) bool {
+	if m.stopping() {
+		return false
+	}
+	if mt, ok := step.messageType(); ok {
+		if mt.Request != models.RequestInit {
+			m.checkAborted(mt.Service)
+			if m.isEnded(mt.Service) {
+				// The session already ended when its credit ran out or
+				// the OCS aborted it.
+				return true
+			}
+		}
+		if !m.reauthorizePending() {
+			return false
+		}
+		answer, err := m.send(mt)
+		if mt.Request != models.RequestTerminate && answer != nil && m.exhausted(mt, answer) {
+			return m.reauthorizePending()
+		}
+		if m.failed(mt.String(), err) && !step.ContinueOnFailure {
+			return false
+		}
+		return m.reauthorizePending()
+	}
 
-		for i := 0; i < m.updateIteration; i++ {
-			time.Sleep(m.sleepTimes)
-			err = m.client.UpdateData(m.accountID, m.sessionData)
-			if err != nil {
-				log.Errorf("update data err: %v", err)
-				return
+	switch {
+	case step.Wait > 0:
+		return m.wait(step.Wait)
+	case step.Loop != nil:
+		for i := 0; i < step.Loop.Count; i++ {
+			if !m.run(step.Loop.Steps) {
+				return false
 			}
 		}
+	case step.Parallel != nil:
+		ok := true
+		var okMu sync.Mutex
+		wg := new(sync.WaitGroup)
+		wg.Add(len(step.Parallel))
+		for _, branch := range step.Parallel {
+			go func(steps []Step) {
+				defer wg.Done()
+				if !m.run(steps) {
+					okMu.Lock()
+					ok = false
+					okMu.Unlock()
+				}
+			}(branch.Steps)
+		}
+		wg.Wait()
+		return ok
+	case step.If != nil:
+		if m.matches(step.If) {
+			return m.run(step.If.Then)
+		}
+		return m.run(step.If.Else)
+	}
+	return true
+}
+
+func (m *account) send(mt models.MessageType) (*diameter.Answer, error) {
+	lock := m.sendLock(mt.Service)
+	lock.Lock()
+	defer lock.Unlock()
+
+	session := m.session(mt)
+	c := m.client
+
+	var answer *diameter.Answer
+	var err error
+	switch mt {
+	case models.DataInit:
+		answer, err = c.InitData(m.accountID, session)
+	case models.DataUpdate:
+		answer, err = c.UpdateData(m.accountID, session)
+	case models.DataTerminate:
+		answer, err = c.TerminateData(m.accountID, session)
+	case models.VoiceCallingInit:
+		answer, err = c.InitVoiceCalling(m.accountID, m.otherID, session)
+	case models.VoiceCallingUpdate:
+		answer, err = c.UpdateVoiceCalling(m.accountID, m.otherID, session)
+	case models.VoiceCallingTerminate:
+		answer, err = c.TerminateVoiceCalling(m.accountID, m.otherID, session)
+	case models.VoiceCalledInit:
+		answer, err = c.InitVoiceCalled(m.accountID, m.otherID, session)
+	case models.VoiceCalledUpdate:
+		answer, err = c.UpdateVoiceCalled(m.accountID, m.otherID, session)
+	case models.VoiceCalledTerminate:
+		answer, err = c.TerminateVoiceCalled(m.accountID, m.otherID, session)
+	case models.VideoCallingInit:
+		answer, err = c.InitVideoCalling(m.accountID, m.otherID, session)
+	case models.VideoCallingUpdate:
+		answer, err = c.UpdateVideoCalling(m.accountID, m.otherID, session)
+	case models.VideoCallingTerminate:
+		answer, err = c.TerminateVideoCalling(m.accountID, m.otherID, session)
+	default:
+		return nil, fmt.Errorf("unsupported message type %s", mt)
+	}
+
+	m.mu.Lock()
+	m.outcomes[mt.Service] = outcome{answer, err}
+	m.last = m.outcomes[mt.Service]
+	if mt.Request == models.RequestTerminate {
+		// RARs still pending for the session get no CCR-U after its CCR-T.
+		m.ended[mt.Service] = true
+	}
+	// A session counts as active from when its flow goes on with it: after
+	// a CCR-I the OCS answered, or one that timed out unless timeouts stop
+	// the flow, as the OCS may have opened it all the same.
+	opened := err == nil || diameter.IsTimeout(err) && !m.abortOnTimeout
+	switch {
+	case mt.Request == models.RequestInit && opened && !m.active[mt.Service]:
+		m.active[mt.Service] = true
+		m.metrics.SessionOpened(mt.Service)
+	case mt.Request == models.RequestTerminate && m.active[mt.Service]:
+		delete(m.active, mt.Service)
+		m.metrics.SessionClosed(mt.Service)
+	}
+	m.mu.Unlock()
+	return answer, err
+}
 
-		err = m.client.TerminateData(m.accountID, m.sessionData)
-		if err != nil {
-			log.Errorf("terminate data err: %v", err)
-			return
+func (m *account) sendLock(service models.Service) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, ok := m.sending[service]
+	if !ok {
+		lock = new(sync.Mutex)
+		m.sending[service] = lock
+	}
+	return lock
+}
+
+// session returns the session of the message's service. Every init starts
+// a new one.
+func (m *account) session(mt models.MessageType) *diameter.Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[mt.Service]
+	if !ok || mt.Request == models.RequestInit {
+		s = diameter.NewSession(fmt.Sprintf("%s:%d:%s", m.accountID, sessionCodes[mt.Service], uuid.New().String()))
+		s.NotifyReAuth(m.reauths)
+		s.NotifyAbort(m.aborts)
+		m.sessions[mt.Service] = s
+		m.ended[mt.Service] = false
+	}
+	return s
+}
+
+// wait sleeps for d. A RAR that comes in meanwhile is followed at once by
+// a CCR-U for its session, an ASR by its CCR-T, and the wait goes on
+// afterwards.
+func (m *account) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case ra := <-m.reauths:
+			if !m.reauthorize(ra) {
+				return false
+			}
+		case s := <-m.aborts:
+			m.abort(s)
+		case <-m.stop:
+			return false
 		}
-	}()
-	//
-	//go func() {
-	//	defer wg.Done()
-	//	m.sessionVoiceCalling = fmt.Sprintf("%s:20:%s", m.accountID, uuid.New().String())
-	//	err := m.client.InitVoiceCalling(m.accountID, m.otherID, m.sessionVoiceCalling)
-	//	if err != nil {
-	//		log.Errorf("init voice calling err: %v", err)
-	//	}
-	//	m.sessionVoiceCalled = fmt.Sprintf("%s:21:%s", m.accountID, uuid.New().String())
-	//	err = m.client.InitVoiceCalled(m.accountID, m.otherID, m.sessionVoiceCalled)
-	//	if err != nil {
-	//		log.Errorf("init voice called err: %v", err)
-	//	}
-	//
-	//	innerWG := new(sync.WaitGroup)
-	//	innerWG.Add(2)
-	//	go func() {
-	//		defer innerWG.Done()
-	//		for i := 0; i < m.updateIteration; i++ {
-	//			time.Sleep(m.sleepTimes)
-	//			err = m.client.UpdateVoiceCalling(m.accountID, m.otherID, m.sessionVoiceCalling)
-	//			if err != nil {
-	//				log.Errorf("update voice calling err: %v", err)
-	//			}
-	//		}
-	//	}()
-	//	go func() {
-	//		defer innerWG.Done()
-	//		for i := 0; i < m.updateIteration; i++ {
-	//			time.Sleep(m.sleepTimes)
-	//			err = m.client.UpdateVoiceCalled(m.accountID, m.otherID, m.sessionVoiceCalled)
-	//			if err != nil {
-	//				log.Errorf("update voice called err: %v", err)
-	//			}
-	//		}
-	//	}()
-	//	innerWG.Wait()
-	//
-	//	err = m.client.TerminateVoiceCalling(m.accountID, m.otherID, m.sessionVoiceCalling)
-	//	if err != nil {
-	//		log.Errorf("terminate voice calling err: %v", err)
-	//	}
-	//	err = m.client.TerminateVoiceCalled(m.accountID, m.otherID, m.sessionVoiceCalled)
-	//	if err != nil {
-	//		log.Errorf("terminate voice called err: %v", err)
-	//	}
-	//}()
-	//
-	//go func() {
-	//	defer wg.Done()
-	//	m.sessionVideoCalling = fmt.Sprintf("%s:20:%s", m.accountID, uuid.New().String())
-	//	err := m.client.InitVideoCalling(m.accountID, m.otherID, m.sessionVideoCalling)
-	//	if err != nil {
-	//		log.Errorf("init video calling err: %v", err)
-	//	}
-	//
-	//	for i := 0; i < m.updateIteration; i++ {
-	//		time.Sleep(m.sleepTimes)
-	//		err = m.client.UpdateVideoCalling(m.accountID, m.otherID, m.sessionVideoCalling)
-	//		if err != nil {
-	//			log.Errorf("update video calling err: %v", err)
-	//		}
-	//	}
-	//
-	//	err = m.client.TerminateVideoCalling(m.accountID, m.otherID, m.sessionVideoCalling)
-	//	if err != nil {
-	//		log.Errorf("terminate video calling err: %v", err)
-	//	}
-	//}()
-
-	wg.Wait()
+	}
+}
+
+func (m *account) stopping() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain terminates the sessions of the flow that are still open.
+func (m *account) drain() {
+	m.mu.Lock()
+	var open []models.Service
+	for service := range m.active {
+		if !m.ended[service] {
+			m.ended[service] = true
+			open = append(open, service)
+		}
+	}
+	m.mu.Unlock()
+	for _, service := range open {
+		m.terminate(service, diameter.ShutdownTermination)
+		m.recorder.RecordDrained(string(service))
+	}
+}
+
+// reauthorizePending sends the CCR-Us of the RARs that came in outside a
+// wait, e.g. while the flow was sending, and reports whether the flow may
+// go on.
+func (m *account) reauthorizePending() bool {
+	for {
+		select {
+		case ra := <-m.reauths:
+			if !m.reauthorize(ra) {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// reauthorize sends the CCR-U a RAR asked for and reports whether the flow
+// may go on.
+func (m *account) reauthorize(ra diameter.ReAuth) bool {
+	service, ok := m.serviceOf(ra.Session)
+	if !ok {
+		// The session ended after the RAR was answered.
+		return true
+	}
+	update := models.MessageType{Service: service, Request: models.RequestUpdate}
+	m.recorder.RecordReAuth(time.Since(ra.At))
+	answer, err := m.send(update)
+	if answer != nil && m.exhausted(update, answer) {
+		return true
+	}
+	return !m.failed(update.String(), err)
+}
+
+// checkAborted ends the service's session if the OCS aborted it.
+func (m *account) checkAborted(service models.Service) {
+	m.mu.Lock()
+	s := m.sessions[service]
+	m.mu.Unlock()
+	if s != nil && s.Aborted() {
+		m.abort(s)
+	}
+}
+
+// abort sends the CCR-T of a session the OCS aborted, reporting its final
+// usage, and counts it. Later updates for the service are skipped.
+func (m *account) abort(s *diameter.Session) {
+	// Parallel branches may both see the ASR; only one ends the session.
+	m.mu.Lock()
+	service, ok := m.openService(s)
+	if ok {
+		m.ended[service] = true
+	}
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	log.Debugf("%s %s: session aborted by the OCS", m.accountID, service)
+	m.terminate(service, diameter.AbortTermination)
+	m.recorder.RecordAborted(string(service))
+}
+
+// serviceOf returns the service whose open session is s.
+func (m *account) serviceOf(s *diameter.Session) (models.Service, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.openService(s)
+}
+
+// openService is serviceOf for callers holding m.mu.
+func (m *account) openService(s *diameter.Session) (models.Service, bool) {
+	for service, open := range m.sessions {
+		if open == s && !m.ended[service] {
+			return service, true
+		}
+	}
+	return "", false
+}
+
+func (m *account) isEnded(service models.Service) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ended[service]
+}
+
+// exhausted reacts to a CCA that says the session's credit is running out,
+// the way a PCEF would, and reports whether it ended the session.
+//
+// On 4012 the session is terminated at once, unless it was the CCR-I that
+// was refused and no session was opened. A Final-Unit-Indication with
+// TERMINATE ends it after the final units, which the CCR-T reports; REDIRECT
+// and RESTRICT_ACCESS send the user to the top-up server, so the final units
+// are reported in a CCR-U and the session only goes on if the OCS grants new
+// units.
+func (m *account) exhausted(mt models.MessageType, answer *diameter.Answer) bool {
+	if answer.CreditLimitReached() {
+		if mt.Request == models.RequestInit {
+			m.mu.Lock()
+			m.ended[mt.Service] = true
+			m.mu.Unlock()
+			m.recorder.RecordExhausted("credit_limit")
+			return true
+		}
+		m.exhaust(mt.Service, diameter.CreditLimitTermination, "credit_limit")
+		return true
+	}
+	fu, ok := answer.FinalUnit()
+	if !ok {
+		return false
+	}
+	if fu.Action == diameter.FinalUnitTerminate {
+		terminate := models.MessageType{Service: mt.Service, Request: models.RequestTerminate}
+		m.session(terminate).ReportFinal()
+		m.exhaust(mt.Service, diameter.FinalUnitTermination, "final_unit_terminate")
+		return true
+	}
+
+	log.Debugf("%s %s: final units, %s to %q", m.accountID, mt, fu, fu.RedirectAddress)
+	update := models.MessageType{Service: mt.Service, Request: models.RequestUpdate}
+	m.session(update).ReportFinal()
+	next, err := m.send(update)
+	if err == nil && next != nil && !next.CreditLimitReached() {
+		if _, again := next.FinalUnit(); !again {
+			return false
+		}
+	}
+	m.exhaust(mt.Service, diameter.FinalUnitTermination, "final_unit_"+fu.String())
+	return true
+}
+
+// exhaust terminates the service's session for reason and counts it.
+func (m *account) exhaust(service models.Service, t diameter.Termination, reason string) {
+	m.mu.Lock()
+	m.ended[service] = true
+	m.mu.Unlock()
+	m.terminate(service, t)
+	m.recorder.RecordExhausted(reason)
+}
+
+// terminate sends the CCR-T of the service's session with t, ahead of the
+// flow.
+func (m *account) terminate(service models.Service, t diameter.Termination) {
+	terminate := models.MessageType{Service: service, Request: models.RequestTerminate}
+	m.session(terminate).SetTermination(t)
+	_, err := m.send(terminate)
+	m.failed(terminate.String(), err)
+}
+
+func (m *account) matches(c *Condition) bool {
+	m.mu.Lock()
+	o := m.last
+	if c.Service != "" {
+		o = m.outcomes[c.Service]
+	}
+	m.mu.Unlock()
+
+	switch c.Result {
+	case "success":
+		return o.answer != nil && o.err == nil
+	case "failure":
+		return o.err != nil && !diameter.IsTimeout(o.err)
+	case "timeout":
+		return diameter.IsTimeout(o.err)
+	default:
+		code, _ := strconv.ParseUint(c.Result, 10, 32)
+		return o.answer != nil && o.answer.Code() == uint32(code)
+	}
 }
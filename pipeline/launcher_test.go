@@ -0,0 +1,431 @@
+package pipeline
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"load-test/diameter"
+	"load-test/models"
+	"load-test/ocs"
+	"load-test/report"
+)
+
+const (
+	typeInitial   = 1
+	typeUpdate    = 2
+	typeTerminate = 3
+)
+
+// startOCS serves the fake OCS on a loopback port, journaling the CCRs it
+// receives.
+func startOCS(t *testing.T, settings ocs.Settings) (*ocs.Server, string) {
+	t.Helper()
+	settings.Addr = "127.0.0.1:0"
+	settings.KeepRequests = true
+	server, err := ocs.New(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := server.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(server.Close)
+	return server, addr.String()
+}
+
+// newClient connects a client with timeout to the OCS at addr.
+func newClient(t *testing.T, addr string, timeout time.Duration, usage diameter.UsagePolicy) (diameter.Client, *report.Recorder) {
+	t.Helper()
+	peer := diameter.DefaultPeerConfig()
+	peer.Address = addr
+	peer.WatchdogInterval = 0
+	hopIDs, sessions := new(sync.Map), new(sync.Map)
+	recorder := report.NewRecorder()
+	router, err := diameter.NewRouter(peer, 1, hopIDs, sessions, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(router.Close)
+	cfg := diameter.DefaultConfig()
+	cfg.Usage = usage
+	return diameter.NewDiameterClient(router, hopIDs, sessions, timeout, recorder, cfg, nil, nil, nil), recorder
+}
+
+// runFlow runs scenario for one account against the OCS settings describe
+// and returns the CCRs of each session in the order they were received.
+func runFlow(t *testing.T, settings ocs.Settings, scenario *Scenario) (map[string][]ocs.Request, *report.Recorder, *ocs.Server) {
+	t.Helper()
+	return runFlowUsing(t, settings, diameter.UsagePolicy{}, scenario)
+}
+
+// runFlowUsing is runFlow with the client reporting usage by policy.
+func runFlowUsing(t *testing.T, settings ocs.Settings, usage diameter.UsagePolicy, scenario *Scenario) (map[string][]ocs.Request, *report.Recorder, *ocs.Server) {
+	t.Helper()
+	server, addr := startOCS(t, settings)
+	client, recorder := newClient(t, addr, 5*time.Second, usage)
+	NewAccount(scenario, 10, client, recorder, nil, models.NewAccountID(1), false, make(chan struct{})).Run()
+	return bySession(server.Requests()), recorder, server
+}
+
+func bySession(requests []ocs.Request) map[string][]ocs.Request {
+	sessions := make(map[string][]ocs.Request)
+	for _, r := range requests {
+		sessions[r.SessionID] = append(sessions[r.SessionID], r)
+	}
+	return sessions
+}
+
+// checkSequence checks that the CCRs of a session have the given types
+// and number 0, 1, 2, ... in the order they were received.
+func checkSequence(t *testing.T, requests []ocs.Request, types ...uint32) {
+	t.Helper()
+	got := make([]uint32, len(requests))
+	for i, r := range requests {
+		got[i] = r.Type
+		if r.Number != uint32(i) {
+			t.Errorf("CCR %d of %s has CC-Request-Number %d, want %d", i, r.SessionID, r.Number, i)
+		}
+	}
+	if len(got) != len(types) {
+		t.Fatalf("session sent CC-Request-Types %v, want %v", got, types)
+	}
+	for i := range types {
+		if got[i] != types[i] {
+			t.Fatalf("session sent CC-Request-Types %v, want %v", got, types)
+		}
+	}
+}
+
+func TestDefaultFlow(t *testing.T) {
+	sessions, recorder, server := runFlow(t, ocs.DefaultSettings(), DefaultScenario(2, 10*time.Millisecond))
+	if len(sessions) != 1 {
+		t.Fatalf("flow opened %d sessions, want 1", len(sessions))
+	}
+	for _, requests := range sessions {
+		checkSequence(t, requests, typeInitial, typeUpdate, typeUpdate, typeTerminate)
+		if cause := requests[3].TerminationCause; cause != diameter.NormalTermination.Cause {
+			t.Errorf("CCR-T Termination-Cause = %d, want %d", cause, diameter.NormalTermination.Cause)
+		}
+		for _, r := range requests[1:] {
+			if len(r.Used) == 0 {
+				t.Errorf("CCR type %d number %d reported no Used-Service-Unit", r.Type, r.Number)
+			}
+		}
+	}
+
+	summary := recorder.Summary()
+	if got := summary.ResultCodes[diameter.ResultSuccess]; got != 4 || len(summary.ResultCodes) != 1 {
+		t.Errorf("result codes %v, want 4 x %d", summary.ResultCodes, diameter.ResultSuccess)
+	}
+	if summary.Total.Errors != 0 || summary.Total.Timeouts != 0 {
+		t.Errorf("flow had %d errors and %d timeouts, want none", summary.Total.Errors, summary.Total.Timeouts)
+	}
+	if stats := server.Stats(); stats.Received != 4 || stats.Answered != 4 {
+		t.Errorf("OCS received %d and answered %d CCRs, want 4", stats.Received, stats.Answered)
+	}
+}
+
+func TestVoiceFlow(t *testing.T) {
+	sessions, recorder, _ := runFlow(t, ocs.DefaultSettings(), VoiceScenario(1, 10*time.Millisecond))
+	if len(sessions) != 2 {
+		t.Fatalf("flow opened %d sessions, want both call legs", len(sessions))
+	}
+	for _, requests := range sessions {
+		checkSequence(t, requests, typeInitial, typeUpdate, typeTerminate)
+	}
+	if got := recorder.Summary().ResultCodes[diameter.ResultSuccess]; got != 6 {
+		t.Errorf("%d CCAs with %d, want 6", got, diameter.ResultSuccess)
+	}
+}
+
+func TestFlowStopsOnError(t *testing.T) {
+	settings := ocs.DefaultSettings()
+	settings.ErrorRate = 1
+	settings.ErrorCode = diameter.ResultUserUnknown
+	sessions, recorder, _ := runFlow(t, settings, DefaultScenario(2, 10*time.Millisecond))
+	for _, requests := range sessions {
+		checkSequence(t, requests, typeInitial)
+	}
+	if got := recorder.Summary().ResultCodes[diameter.ResultUserUnknown]; got != 1 {
+		t.Errorf("%d CCAs with %d, want the refused CCR-I only", got, diameter.ResultUserUnknown)
+	}
+}
+
+// only returns the CCRs of the single session in sessions.
+func only(t *testing.T, sessions map[string][]ocs.Request) []ocs.Request {
+	t.Helper()
+	if len(sessions) != 1 {
+		t.Fatalf("flow opened %d sessions, want 1", len(sessions))
+	}
+	for _, requests := range sessions {
+		return requests
+	}
+	return nil
+}
+
+// usedOf returns the single Used-Service-Unit r reported.
+func usedOf(t *testing.T, r ocs.Request) ocs.UsedUnits {
+	t.Helper()
+	if len(r.Used) != 1 {
+		t.Fatalf("CCR type %d number %d reported %d Used-Service-Units, want 1", r.Type, r.Number, len(r.Used))
+	}
+	return r.Used[0]
+}
+
+func checkExhausted(t *testing.T, recorder *report.Recorder, reason string) {
+	t.Helper()
+	if got := recorder.Summary().ExhaustedSessions; got[reason] != 1 || len(got) != 1 {
+		t.Errorf("exhausted sessions %v, want one %s", got, reason)
+	}
+}
+
+func TestFinalUnitTerminate(t *testing.T) {
+	settings := ocs.DefaultSettings()
+	settings.FinalUnitAfter = 1
+	sessions, recorder, _ := runFlow(t, settings, DefaultScenario(2, 10*time.Millisecond))
+	requests := only(t, sessions)
+
+	// The CCA to the first CCR-U carries the final units; the CCR-T follows
+	// at once and the flow's second update is skipped.
+	checkSequence(t, requests, typeInitial, typeUpdate, typeTerminate)
+	terminate := requests[2]
+	if terminate.TerminationCause != diameter.FinalUnitTermination.Cause {
+		t.Errorf("CCR-T Termination-Cause = %d, want %d", terminate.TerminationCause, diameter.FinalUnitTermination.Cause)
+	}
+	used := usedOf(t, terminate)
+	if used.InputOctets+used.OutputOctets != settings.GrantedOctets || used.Time != settings.GrantedTime {
+		t.Errorf("CCR-T reported %+v, want all of the final %d octets and %ds", used, settings.GrantedOctets, settings.GrantedTime)
+	}
+	if used.ReportingReason != diameter.ReportingReasonFinal {
+		t.Errorf("CCR-T Reporting-Reason = %d, want FINAL", used.ReportingReason)
+	}
+	checkExhausted(t, recorder, "final_unit_terminate")
+}
+
+func TestFinalUnitRedirect(t *testing.T) {
+	tests := []struct {
+		name   string
+		usage  diameter.UsagePolicy
+		action string
+		all    bool
+	}{
+		{name: "redirect", usage: diameter.UsagePolicy{Mode: diameter.UsageFixed}, action: "redirect"},
+		{name: "restrict", usage: diameter.UsagePolicy{Mode: diameter.UsageFixed}, action: "restrict"},
+		{name: "redirect reporting all", usage: diameter.UsagePolicy{Mode: diameter.UsageAll}, action: "redirect", all: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := ocs.DefaultSettings()
+			settings.FinalUnitAfter = 1
+			settings.FinalUnitAction = tt.action
+			sessions, recorder, _ := runFlowUsing(t, settings, tt.usage, DefaultScenario(2, 10*time.Millisecond))
+			requests := only(t, sessions)
+
+			// The final units are reported in a CCR-U; its answer carries
+			// final units again, so the session ends.
+			checkSequence(t, requests, typeInitial, typeUpdate, typeUpdate, typeTerminate)
+			if reason := usedOf(t, requests[1]).ReportingReason; reason != diameter.ReportingReasonQuotaExhausted {
+				t.Errorf("first CCR-U Reporting-Reason = %d, want QUOTA_EXHAUSTED", reason)
+			}
+			final := usedOf(t, requests[2])
+			if final.ReportingReason != diameter.ReportingReasonFinal {
+				t.Errorf("final CCR-U Reporting-Reason = %d, want FINAL", final.ReportingReason)
+			}
+			if tt.all && (final.InputOctets+final.OutputOctets != settings.GrantedOctets || final.Time != settings.GrantedTime) {
+				t.Errorf("final CCR-U reported %+v, want all of the final grant", final)
+			}
+			if cause := requests[3].TerminationCause; cause != diameter.FinalUnitTermination.Cause {
+				t.Errorf("CCR-T Termination-Cause = %d, want %d", cause, diameter.FinalUnitTermination.Cause)
+			}
+			checkExhausted(t, recorder, "final_unit_"+tt.action)
+		})
+	}
+}
+
+func TestCreditLimitReached(t *testing.T) {
+	settings := ocs.DefaultSettings()
+	settings.CreditLimitAfter = 2
+	sessions, recorder, _ := runFlow(t, settings, DefaultScenario(3, 10*time.Millisecond))
+	requests := only(t, sessions)
+
+	// The second CCR-U is refused with 4012 and the CCR-T follows at once.
+	checkSequence(t, requests, typeInitial, typeUpdate, typeUpdate, typeTerminate)
+	terminate := requests[3]
+	if terminate.TerminationCause != diameter.CreditLimitTermination.Cause {
+		t.Errorf("CCR-T Termination-Cause = %d, want %d", terminate.TerminationCause, diameter.CreditLimitTermination.Cause)
+	}
+	if reason := usedOf(t, terminate).ReportingReason; reason != diameter.CreditLimitTermination.ReportingReason {
+		t.Errorf("CCR-T Reporting-Reason = %d, want %d", reason, diameter.CreditLimitTermination.ReportingReason)
+	}
+	checkExhausted(t, recorder, "credit_limit")
+	if got := recorder.Summary().ResultCodes[diameter.ResultCreditLimitReached]; got != 1 {
+		t.Errorf("%d CCAs with 4012, want 1", got)
+	}
+}
+
+func TestCreditLimitOnInit(t *testing.T) {
+	settings := ocs.DefaultSettings()
+	settings.ErrorRate = 1
+	settings.ErrorCode = diameter.ResultCreditLimitReached
+	sessions, recorder, _ := runFlow(t, settings, DefaultScenario(2, 10*time.Millisecond))
+
+	// No session was opened, so there is nothing to terminate.
+	checkSequence(t, only(t, sessions), typeInitial)
+	checkExhausted(t, recorder, "credit_limit")
+}
+
+// waitScenario opens a data session, waits for wait, sends updates CCR-Us
+// and terminates.
+func waitScenario(wait time.Duration, updates int) *Scenario {
+	steps := []Step{{Init: models.ServiceData}, {Wait: wait}}
+	for i := 0; i < updates; i++ {
+		steps = append(steps, Step{Update: models.ServiceData})
+	}
+	return &Scenario{Name: "data", Steps: append(steps, Step{Terminate: models.ServiceData})}
+}
+
+func TestReAuthDuringWait(t *testing.T) {
+	const wait = 300 * time.Millisecond
+	settings := ocs.DefaultSettings()
+	settings.ReAuthAfter = 50 * time.Millisecond
+	sessions, recorder, server := runFlow(t, settings, waitScenario(wait, 1))
+	requests := only(t, sessions)
+
+	// The RAR adds one CCR-U, sent at once rather than after the wait.
+	checkSequence(t, requests, typeInitial, typeUpdate, typeUpdate, typeTerminate)
+	if after := requests[1].Received.Sub(requests[0].Received); after >= wait {
+		t.Errorf("CCR-U for the RAR sent %v after the CCR-I, want it before the %v wait ends", after, wait)
+	}
+	if after := requests[2].Received.Sub(requests[0].Received); after < wait {
+		t.Errorf("flow's CCR-U sent %v after the CCR-I, want it after the %v wait", after, wait)
+	}
+	if stats := server.Stats(); stats.ReAuths != 1 || stats.ReAuthAnswers != 1 {
+		t.Errorf("OCS sent %d RARs and got %d RAAs, want 1 and 1", stats.ReAuths, stats.ReAuthAnswers)
+	}
+	if got := recorder.Summary().ReAuth.Count; got != 1 {
+		t.Errorf("recorded %d reauthorizations, want 1", got)
+	}
+}
+
+func TestReAuthDuringSend(t *testing.T) {
+	latency, err := ocs.ParseLatency("50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := ocs.DefaultSettings()
+	settings.Latency = latency
+	// The RAR comes in while the flow's CCR-U waits for its answer.
+	settings.ReAuthAfter = 75 * time.Millisecond
+	sessions, _, server := runFlow(t, settings, waitScenario(0, 1))
+	requests := only(t, sessions)
+
+	checkSequence(t, requests, typeInitial, typeUpdate, typeUpdate, typeTerminate)
+	if stats := server.Stats(); stats.ReAuthAnswers != 1 {
+		t.Errorf("OCS got %d RAAs, want 1", stats.ReAuthAnswers)
+	}
+}
+
+func TestAbortDuringWait(t *testing.T) {
+	const wait = 300 * time.Millisecond
+	settings := ocs.DefaultSettings()
+	settings.AbortAfter = 50 * time.Millisecond
+	sessions, recorder, server := runFlow(t, settings, waitScenario(wait, 2))
+	requests := only(t, sessions)
+
+	// The ASR is followed at once by the CCR-T; the flow's updates and its
+	// own CCR-T are skipped.
+	checkSequence(t, requests, typeInitial, typeTerminate)
+	terminate := requests[1]
+	if terminate.TerminationCause != diameter.AbortTermination.Cause {
+		t.Errorf("CCR-T Termination-Cause = %d, want %d (ADMINISTRATIVE)", terminate.TerminationCause, diameter.AbortTermination.Cause)
+	}
+	if after := terminate.Received.Sub(requests[0].Received); after >= wait {
+		t.Errorf("CCR-T sent %v after the CCR-I, want it before the %v wait ends", after, wait)
+	}
+	if stats := server.Stats(); stats.Aborts != 1 || stats.AbortAnswers != 1 {
+		t.Errorf("OCS sent %d ASRs and got %d ASAs, want 1 and 1", stats.Aborts, stats.AbortAnswers)
+	}
+	if got := recorder.Summary().AbortedSessions; got["data"] != 1 {
+		t.Errorf("aborted sessions %v, want one data session", got)
+	}
+}
+
+func TestAbortBothLegs(t *testing.T) {
+	settings := ocs.DefaultSettings()
+	settings.AbortAfter = 50 * time.Millisecond
+	sessions, _, server := runFlow(t, settings, VoiceScenario(2, 100*time.Millisecond))
+	if len(sessions) != 2 {
+		t.Fatalf("flow opened %d sessions, want both call legs", len(sessions))
+	}
+	for _, requests := range sessions {
+		checkSequence(t, requests, typeInitial, typeTerminate)
+		if cause := requests[1].TerminationCause; cause != diameter.AbortTermination.Cause {
+			t.Errorf("CCR-T Termination-Cause = %d, want %d", cause, diameter.AbortTermination.Cause)
+		}
+	}
+	if stats := server.Stats(); stats.AbortAnswers != 2 {
+		t.Errorf("OCS got %d ASAs, want 2", stats.AbortAnswers)
+	}
+}
+
+// timeoutClient times out every CCR-I and answers nothing else but the
+// CCR-Ts, whose terminations it keeps.
+type timeoutClient struct {
+	diameter.Client
+
+	mu           sync.Mutex
+	terminations []diameter.Termination
+}
+
+func (c *timeoutClient) InitData(accountID models.AccountID, session *diameter.Session) (*diameter.Answer, error) {
+	return nil, &diameter.TimeoutError{Type: models.DataInit, AccountID: accountID}
+}
+
+func (c *timeoutClient) TerminateData(accountID models.AccountID, session *diameter.Session) (*diameter.Answer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.terminations = append(c.terminations, session.Termination())
+	return nil, nil
+}
+
+func TestDrainAfterInitTimeout(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		abortOnTimeout bool
+		want           []diameter.Termination
+	}{
+		// The flow goes on with the session, so stopping the run
+		// terminates it.
+		{"flow goes on", false, []diameter.Termination{diameter.ShutdownTermination}},
+		{"flow stops", true, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := new(timeoutClient)
+			recorder := report.NewRecorder()
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				NewAccount(waitScenario(time.Hour, 1), 10, client, recorder, nil, models.NewAccountID(1), test.abortOnTimeout, stop).Run()
+			}()
+			time.Sleep(50 * time.Millisecond)
+			close(stop)
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("flow did not end when the run stopped")
+			}
+
+			if !reflect.DeepEqual(client.terminations, test.want) {
+				t.Errorf("terminations = %v, want %v", client.terminations, test.want)
+			}
+			if got, want := recorder.Summary().DrainedSessions["data"], uint64(len(test.want)); got != want {
+				t.Errorf("drained %d data sessions, want %d", got, want)
+			}
+		})
+	}
+}
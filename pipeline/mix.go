@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type mixEntry struct {
+	name     string
+	weight   float64
+	scenario *Scenario
+}
+
+// Mix assigns each new account session a scenario by weighted random choice.
+type Mix struct {
+	entries []mixEntry
+	total   float64
+}
+
+// SingleMix runs scenario for every session.
+func SingleMix(scenario *Scenario) *Mix {
+	return &Mix{entries: []mixEntry{{scenario.Name, 1, scenario}}, total: 1}
+}
+
+// ParseMix parses a traffic mix such as "data=70,voice=25,video=5". The
+// names data, voice and video are the built-in flows; any other name is
+// loaded as a scenario file.
+func ParseMix(spec string, updateIteration int, sleepTimes time.Duration) (*Mix, error) {
+	builtin := map[string]func(int, time.Duration) *Scenario{
+		"data":  DefaultScenario,
+		"voice": VoiceScenario,
+		"video": VideoScenario,
+	}
+
+	m := &Mix{}
+	for _, part := range strings.Split(spec, ",") {
+		name, w, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("mix entry %q is not name=weight", part)
+		}
+		weight, err := strconv.ParseFloat(w, 64)
+		if err != nil || weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("invalid weight %q for %s", w, name)
+		}
+		if weight == 0 {
+			continue
+		}
+
+		var scenario *Scenario
+		if build, ok := builtin[name]; ok {
+			scenario = build(updateIteration, sleepTimes)
+		} else if scenario, err = LoadScenario(name); err != nil {
+			return nil, err
+		}
+		m.entries = append(m.entries, mixEntry{name, weight, scenario})
+		m.total += weight
+	}
+	if len(m.entries) == 0 {
+		return nil, fmt.Errorf("mix %q has no positive weights", spec)
+	}
+	return m, nil
+}
+
+// Pick returns the name and scenario of a randomly chosen entry.
+func (m *Mix) Pick() (string, *Scenario) {
+	if len(m.entries) == 1 {
+		return m.entries[0].name, m.entries[0].scenario
+	}
+	r := rand.Float64() * m.total
+	for _, e := range m.entries {
+		if r < e.weight {
+			return e.name, e.scenario
+		}
+		r -= e.weight
+	}
+	last := m.entries[len(m.entries)-1]
+	return last.name, last.scenario
+}
+
+func (m *Mix) String() string {
+	parts := make([]string, len(m.entries))
+	for i, e := range m.entries {
+		parts[i] = fmt.Sprintf("%s=%.1f%%", e.name, e.weight/m.total*100)
+	}
+	return strings.Join(parts, ",")
+}
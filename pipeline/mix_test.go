@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+		err  bool
+	}{
+		{spec: "data=70,voice=25,video=5", want: "data=70.0%,voice=25.0%,video=5.0%"},
+		{spec: "data=1, voice=1", want: "data=50.0%,voice=50.0%"},
+		{spec: "data=3,voice=0", want: "data=100.0%"},
+		{spec: "data=0.5,video=1.5", want: "data=25.0%,video=75.0%"},
+
+		{spec: "data", err: true},
+		{spec: "data=", err: true},
+		{spec: "data=heavy", err: true},
+		{spec: "data=-1,voice=2", err: true},
+		{spec: "data=NaN", err: true},
+		{spec: "data=1,voice=NaN", err: true},
+		{spec: "voice=Inf", err: true},
+		{spec: "voice=-Inf,data=1", err: true},
+		{spec: "data=0,voice=0", err: true},
+		{spec: "missing-scenario.yaml=1", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			mix, err := ParseMix(tt.spec, 1, time.Second)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ParseMix(%q) = %v, want an error", tt.spec, mix)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMix(%q): %v", tt.spec, err)
+			}
+			if got := mix.String(); got != tt.want {
+				t.Errorf("ParseMix(%q) = %q, want %q", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMixPick(t *testing.T) {
+	mix, err := ParseMix("data=3,voice=1", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const picks = 20000
+	counts := map[string]int{}
+	for i := 0; i < picks; i++ {
+		name, scenario := mix.Pick()
+		if scenario == nil {
+			t.Fatalf("Pick returned no scenario for %s", name)
+		}
+		counts[name]++
+	}
+	if len(counts) != 2 {
+		t.Fatalf("Pick chose %v, want data and voice", counts)
+	}
+	if share := float64(counts["data"]) / picks; share < 0.72 || share > 0.78 {
+		t.Errorf("Pick chose data %.1f%% of the time, want about 75%%", share*100)
+	}
+}
@@ -0,0 +1,209 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"load-test/models"
+)
+
+// Scenario is a declarative session flow, interpreted once per account:
+//
+//	name: data-session
+//	steps:
+//	  - init: data
+//	  - loop:
+//	      count: 2
+//	      steps:
+//	        - wait: 1s
+//	        - update: data
+//	  - terminate: data
+//
+// A step is exactly one of init, update, terminate (naming a service),
+// wait, loop, parallel or if.
+type Scenario struct {
+	Name  string `yaml:"name"`
+	Steps []Step `yaml:"steps"`
+}
+
+type Step struct {
+	Init      models.Service `yaml:"init,omitempty"`
+	Update    models.Service `yaml:"update,omitempty"`
+	Terminate models.Service `yaml:"terminate,omitempty"`
+	// ContinueOnFailure keeps the flow going after a failed answer, so a
+	// following if step can branch on it.
+	ContinueOnFailure bool `yaml:"continue_on_failure,omitempty"`
+
+	Wait     time.Duration `yaml:"wait,omitempty"`
+	Loop     *Loop         `yaml:"loop,omitempty"`
+	Parallel []Branch      `yaml:"parallel,omitempty"`
+	If       *Condition    `yaml:"if,omitempty"`
+}
+
+type Loop struct {
+	Count int    `yaml:"count"`
+	Steps []Step `yaml:"steps"`
+}
+
+type Branch struct {
+	Steps []Step `yaml:"steps"`
+}
+
+// Condition branches on the outcome of the last CCR sent for Service, or
+// the last CCR of the flow when Service is empty. Result is "success",
+// "failure", "timeout" or a numeric Result-Code.
+type Condition struct {
+	Service models.Service `yaml:"service,omitempty"`
+	Result  string         `yaml:"result"`
+	Then    []Step         `yaml:"then,omitempty"`
+	Else    []Step         `yaml:"else,omitempty"`
+}
+
+// DefaultScenario is the flow the tool always ran: one data session with
+// updateIteration updates, sleepTimes apart.
+func DefaultScenario(updateIteration int, sleepTimes time.Duration) *Scenario {
+	return &Scenario{
+		Name: "data",
+		Steps: []Step{
+			{Init: models.ServiceData},
+			{Loop: &Loop{
+				Count: updateIteration,
+				Steps: []Step{{Wait: sleepTimes}, {Update: models.ServiceData}},
+			}},
+			{Terminate: models.ServiceData},
+		},
+	}
+}
+
+// VoiceScenario sets up both legs of a call, updates them side by side
+// and tears them down.
+func VoiceScenario(updateIteration int, sleepTimes time.Duration) *Scenario {
+	leg := func(service models.Service) Branch {
+		return Branch{Steps: []Step{{Loop: &Loop{
+			Count: updateIteration,
+			Steps: []Step{{Wait: sleepTimes}, {Update: service}},
+		}}}}
+	}
+	return &Scenario{
+		Name: "voice",
+		Steps: []Step{
+			{Init: models.ServiceVoiceCalling},
+			{Init: models.ServiceVoiceCalled},
+			{Parallel: []Branch{leg(models.ServiceVoiceCalling), leg(models.ServiceVoiceCalled)}},
+			{Terminate: models.ServiceVoiceCalling},
+			{Terminate: models.ServiceVoiceCalled},
+		},
+	}
+}
+
+func VideoScenario(updateIteration int, sleepTimes time.Duration) *Scenario {
+	return &Scenario{
+		Name: "video",
+		Steps: []Step{
+			{Init: models.ServiceVideoCalling},
+			{Loop: &Loop{
+				Count: updateIteration,
+				Steps: []Step{{Wait: sleepTimes}, {Update: models.ServiceVideoCalling}},
+			}},
+			{Terminate: models.ServiceVideoCalling},
+		},
+	}
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read scenario")
+	}
+	s := &Scenario{}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse scenario %s", path)
+	}
+	if s.Name == "" {
+		s.Name = path
+	}
+	if err := validateSteps(s.Steps, "steps"); err != nil {
+		return nil, errors.Wrapf(err, "invalid scenario %s", path)
+	}
+	return s, nil
+}
+
+var services = map[models.Service]bool{
+	models.ServiceData:         true,
+	models.ServiceVoiceCalling: true,
+	models.ServiceVoiceCalled:  true,
+	models.ServiceVideoCalling: true,
+}
+
+func validateSteps(steps []Step, path string) error {
+	for i, step := range steps {
+		at := fmt.Sprintf("%s[%d]", path, i)
+		kinds := 0
+		for _, set := range []bool{
+			step.Init != "", step.Update != "", step.Terminate != "",
+			step.Wait != 0, step.Loop != nil, step.Parallel != nil, step.If != nil,
+		} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return fmt.Errorf("%s: a step needs exactly one of init, update, terminate, wait, loop, parallel or if", at)
+		}
+
+		if mt, ok := step.messageType(); ok && !services[mt.Service] {
+			return fmt.Errorf("%s: unknown service %q", at, mt.Service)
+		}
+		switch {
+		case step.Wait < 0:
+			return fmt.Errorf("%s: negative wait", at)
+		case step.Loop != nil:
+			if step.Loop.Count < 0 {
+				return fmt.Errorf("%s: negative loop count", at)
+			}
+			if err := validateSteps(step.Loop.Steps, at+".loop.steps"); err != nil {
+				return err
+			}
+		case step.Parallel != nil:
+			for j, b := range step.Parallel {
+				if err := validateSteps(b.Steps, fmt.Sprintf("%s.parallel[%d].steps", at, j)); err != nil {
+					return err
+				}
+			}
+		case step.If != nil:
+			if step.If.Service != "" && !services[step.If.Service] {
+				return fmt.Errorf("%s: unknown service %q", at, step.If.Service)
+			}
+			switch step.If.Result {
+			case "success", "failure", "timeout":
+			default:
+				if _, err := strconv.ParseUint(step.If.Result, 10, 32); err != nil {
+					return fmt.Errorf("%s: if result must be success, failure, timeout or a Result-Code", at)
+				}
+			}
+			if err := validateSteps(step.If.Then, at+".if.then"); err != nil {
+				return err
+			}
+			if err := validateSteps(step.If.Else, at+".if.else"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s Step) messageType() (models.MessageType, bool) {
+	switch {
+	case s.Init != "":
+		return models.MessageType{Service: s.Init, Request: models.RequestInit}, true
+	case s.Update != "":
+		return models.MessageType{Service: s.Update, Request: models.RequestUpdate}, true
+	case s.Terminate != "":
+		return models.MessageType{Service: s.Terminate, Request: models.RequestTerminate}, true
+	}
+	return models.MessageType{}, false
+}
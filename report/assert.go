@@ -0,0 +1,268 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kind is what an assertion metric measures, which decides how its
+// threshold is written and its value printed.
+type kind int
+
+const (
+	kindLatency    kind = iota // a duration, e.g. 50ms
+	kindRate                   // a fraction, written 0.999 or 99.9%
+	kindCount                  // a number of requests or sessions
+	kindThroughput             // requests per second
+)
+
+// metric reads one figure of the stats selected by an assertion.
+type metric struct {
+	kind kind
+	get  func(st Stats) float64
+	// session metrics take no selector and read the summary instead.
+	session func(s *Summary) float64
+}
+
+func latency(f func(l Latency) time.Duration) metric {
+	return metric{kind: kindLatency, get: func(st Stats) float64 { return float64(f(st.Latency)) }}
+}
+
+var assertionMetrics = map[string]metric{
+	"p50":  latency(func(l Latency) time.Duration { return l.P50 }),
+	"p90":  latency(func(l Latency) time.Duration { return l.P90 }),
+	"p99":  latency(func(l Latency) time.Duration { return l.P99 }),
+	"p999": latency(func(l Latency) time.Duration { return l.P999 }),
+	"mean": latency(func(l Latency) time.Duration { return l.Mean }),
+	"max":  latency(func(l Latency) time.Duration { return l.Max }),
+
+	"success_rate": {kind: kindRate, get: func(st Stats) float64 {
+		if st.Count == 0 {
+			return 0
+		}
+		return float64(st.Count-st.Errors-st.Timeouts) / float64(st.Count)
+	}},
+	"error_rate":   {kind: kindRate, get: func(st Stats) float64 { return st.ErrorRate }},
+	"timeout_rate": {kind: kindRate, get: func(st Stats) float64 { return st.TimeoutRate }},
+
+	"requests":   {kind: kindCount, get: func(st Stats) float64 { return float64(st.Count) }},
+	"errors":     {kind: kindCount, get: func(st Stats) float64 { return float64(st.Errors) }},
+	"timeouts":   {kind: kindCount, get: func(st Stats) float64 { return float64(st.Timeouts) }},
+	"throughput": {kind: kindThroughput, get: func(st Stats) float64 { return st.Throughput }},
+
+	"never_terminated": {kind: kindCount, session: func(s *Summary) float64 {
+		return float64(s.States.Init + s.States.Active)
+	}},
+	"failed_sessions": {kind: kindCount, session: func(s *Summary) float64 {
+		return float64(s.States.Failed)
+	}},
+}
+
+var operators = map[string]func(a, b float64) bool{
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+var assertionPattern = regexp.MustCompile(`^\s*([a-z0-9_]+)\s*(?:\(\s*([^)\s]*)\s*\))?\s*(<=|>=|==|!=|<|>)\s*(\S+)\s*$`)
+
+// Assertion is an SLA threshold checked against the summary of a run, e.g.
+// "p99(data.update) < 50ms", "success_rate > 99.9%" or "timeouts == 0".
+// The selector in parentheses is a message type or a service; without one
+// the totals are checked.
+type Assertion struct {
+	text     string
+	metric   metric
+	selector string
+	op       string
+	value    float64
+}
+
+func ParseAssertion(text string) (Assertion, error) {
+	m := assertionPattern.FindStringSubmatch(text)
+	if m == nil {
+		return Assertion{}, fmt.Errorf("invalid assertion %q: want metric[(type|service)] op value", text)
+	}
+	name, selector, op, value := m[1], m[2], m[3], m[4]
+	met, ok := assertionMetrics[name]
+	if !ok {
+		return Assertion{}, fmt.Errorf("unknown metric %q in assertion %q", name, text)
+	}
+	if met.session != nil && selector != "" {
+		return Assertion{}, fmt.Errorf("%s takes no selector in assertion %q", name, text)
+	}
+	v, err := parseThreshold(met.kind, value)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("invalid value in assertion %q: %v", text, err)
+	}
+	return Assertion{
+		text:     strings.TrimSpace(text),
+		metric:   met,
+		selector: selector,
+		op:       op,
+		value:    v,
+	}, nil
+}
+
+func parseThreshold(k kind, s string) (float64, error) {
+	switch k {
+	case kindLatency:
+		d, err := time.ParseDuration(s)
+		return float64(d), err
+	case kindRate:
+		if p, ok := strings.CutSuffix(s, "%"); ok {
+			// Scaled in the parse, as 99.9/100 is not the nearest float to 0.999.
+			return strconv.ParseFloat(p+"e-2", 64)
+		}
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func (a Assertion) String() string {
+	return a.text
+}
+
+func (a *Assertion) UnmarshalText(text []byte) error {
+	v, err := ParseAssertion(string(text))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+func (a Assertion) MarshalText() ([]byte, error) {
+	return []byte(a.text), nil
+}
+
+// Check evaluates the assertion against s.
+func (a Assertion) Check(s *Summary) Check {
+	c := Check{Assertion: a.text}
+	var actual float64
+	switch {
+	case a.metric.session != nil:
+		actual = a.metric.session(s)
+	default:
+		st, ok := s.stats(a.selector)
+		if !ok {
+			c.Actual = "no " + a.selector + " requests"
+			return c
+		}
+		actual = a.metric.get(st)
+	}
+	c.Actual = formatValue(a.metric.kind, actual)
+	c.Passed = operators[a.op](actual, a.value)
+	return c
+}
+
+// stats returns the totals, or the stats of a message type or service.
+func (s *Summary) stats(selector string) (Stats, bool) {
+	if selector == "" {
+		return s.Total, true
+	}
+	for _, st := range s.Types {
+		if st.Type == selector {
+			return st, true
+		}
+	}
+	for _, st := range s.Services {
+		if st.Type == selector {
+			return st, true
+		}
+	}
+	return Stats{}, false
+}
+
+func formatValue(k kind, v float64) string {
+	switch k {
+	case kindLatency:
+		return round(time.Duration(v)).String()
+	case kindRate:
+		return strconv.FormatFloat(v*100, 'f', 3, 64) + "%"
+	case kindThroughput:
+		return strconv.FormatFloat(v, 'f', 1, 64) + "/s"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Assertions is a list of assertions, written separated by semicolons on
+// the command line.
+type Assertions []Assertion
+
+func (l *Assertions) UnmarshalText(text []byte) error {
+	var list Assertions
+	for _, entry := range strings.Split(string(text), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		a, err := ParseAssertion(entry)
+		if err != nil {
+			return err
+		}
+		list = append(list, a)
+	}
+	*l = list
+	return nil
+}
+
+func (l Assertions) MarshalText() ([]byte, error) {
+	texts := make([]string, len(l))
+	for i, a := range l {
+		texts[i] = a.text
+	}
+	return []byte(strings.Join(texts, "; ")), nil
+}
+
+// Check evaluates every assertion against s.
+func (l Assertions) Check(s *Summary) []Check {
+	var checks []Check
+	for _, a := range l {
+		checks = append(checks, a.Check(s))
+	}
+	return checks
+}
+
+// Check is the outcome of one assertion.
+type Check struct {
+	Assertion string `json:"assertion"`
+	Actual    string `json:"actual"`
+	Passed    bool   `json:"passed"`
+}
+
+// Passed reports whether every check of the summary passed.
+func (s *Summary) Passed() bool {
+	for _, c := range s.Checks {
+		if !c.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func printChecks(w io.Writer, checks []Check) {
+	if len(checks) == 0 {
+		return
+	}
+	failed := 0
+	fmt.Fprintln(w, "\nSLA checks:")
+	for _, c := range checks {
+		mark := "PASS"
+		if !c.Passed {
+			mark = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "  [%s] %s (actual %s)\n", mark, c.Assertion, c.Actual)
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "%d of %d SLA checks failed\n", failed, len(checks))
+	} else {
+		fmt.Fprintf(w, "All %d SLA checks passed\n", len(checks))
+	}
+}
@@ -0,0 +1,166 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAssertion(t *testing.T) {
+	tests := []struct {
+		text     string
+		selector string
+		op       string
+		value    float64
+		err      bool
+	}{
+		{text: "p99(data.update) < 50ms", selector: "data.update", op: "<", value: float64(50 * time.Millisecond)},
+		{text: "  p999 ( data ) <= 1s ", selector: "data", op: "<=", value: float64(time.Second)},
+		{text: "success_rate > 99.9%", op: ">", value: 0.999},
+		{text: "error_rate < 0.01", op: "<", value: 0.01},
+		{text: "timeouts == 0", op: "==", value: 0},
+		{text: "requests>=1000", op: ">=", value: 1000},
+		{text: "throughput(voice) != 0", selector: "voice", op: "!=", value: 0},
+		{text: "never_terminated == 0", op: "==", value: 0},
+
+		{text: "", err: true},
+		{text: "p99", err: true},
+		{text: "p99 < ", err: true},
+		{text: "p99 =< 50ms", err: true},
+		{text: "p99 < 50", err: true},
+		{text: "p99 < fast", err: true},
+		{text: "p95 < 50ms", err: true},
+		{text: "success_rate > most%", err: true},
+		{text: "timeouts == none", err: true},
+		{text: "failed_sessions(data) == 0", err: true},
+		{text: "p99(data.update < 50ms", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			a, err := ParseAssertion(tt.text)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ParseAssertion(%q) = %+v, want an error", tt.text, a)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAssertion(%q): %v", tt.text, err)
+			}
+			if a.selector != tt.selector || a.op != tt.op || a.value != tt.value {
+				t.Errorf("ParseAssertion(%q) = %q %s %v, want %q %s %v",
+					tt.text, a.selector, a.op, a.value, tt.selector, tt.op, tt.value)
+			}
+			if a.String() != strings.TrimSpace(tt.text) {
+				t.Errorf("String() = %q, want %q", a.String(), strings.TrimSpace(tt.text))
+			}
+		})
+	}
+}
+
+func TestAssertionsUnmarshalText(t *testing.T) {
+	var l Assertions
+	if err := l.UnmarshalText([]byte("p99(data.update) < 50ms; success_rate > 99.9%;; timeouts == 0;")); err != nil {
+		t.Fatal(err)
+	}
+	text, _ := l.MarshalText()
+	if want := "p99(data.update) < 50ms; success_rate > 99.9%; timeouts == 0"; string(text) != want {
+		t.Errorf("MarshalText = %q, want %q", text, want)
+	}
+	if err := l.UnmarshalText([]byte("timeouts == 0; p99 < soon")); err == nil {
+		t.Error("UnmarshalText with a malformed assertion succeeded, want an error")
+	}
+}
+
+func testSummary() *Summary {
+	return &Summary{
+		Types: []Stats{{
+			Type:    "data.update",
+			Count:   1000,
+			Errors:  1,
+			Latency: Latency{P50: 10 * time.Millisecond, P99: 40 * time.Millisecond},
+		}},
+		Services: []Stats{{Type: "data", Count: 3000, Errors: 1, Throughput: 250}},
+		Total: Stats{
+			Count:      4000,
+			Errors:     1,
+			Timeouts:   2,
+			ErrorRate:  0.00025,
+			Throughput: 333.3,
+			Latency:    Latency{P99: 60 * time.Millisecond},
+		},
+		States: SessionStates{Terminated: 1000, Active: 2},
+	}
+}
+
+func TestAssertionCheck(t *testing.T) {
+	tests := []struct {
+		text   string
+		actual string
+		passed bool
+	}{
+		{text: "p99(data.update) < 50ms", actual: "40ms", passed: true},
+		{text: "p99 < 50ms", actual: "60ms"},
+		{text: "success_rate > 99.9%", actual: "99.925%", passed: true},
+		{text: "success_rate(data.update) >= 99.95%", actual: "99.900%"},
+		{text: "success_rate(data.update) >= 99.9%", actual: "99.900%", passed: true},
+		{text: "error_rate < 0.1%", actual: "0.025%", passed: true},
+		{text: "timeouts == 0", actual: "2"},
+		{text: "timeouts(data.update) == 0", actual: "0", passed: true},
+		{text: "requests(data) > 1000", actual: "3000", passed: true},
+		{text: "throughput(data) >= 200", actual: "250.0/s", passed: true},
+		{text: "never_terminated == 0", actual: "2"},
+		{text: "failed_sessions == 0", actual: "0", passed: true},
+		{text: "p99(voice) < 50ms", actual: "no voice requests"},
+	}
+	s := testSummary()
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			a, err := ParseAssertion(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := a.Check(s)
+			if c.Actual != tt.actual || c.Passed != tt.passed {
+				t.Errorf("Check = %s, passed %v, want %s, passed %v", c.Actual, c.Passed, tt.actual, tt.passed)
+			}
+		})
+	}
+}
+
+func TestSummaryPassed(t *testing.T) {
+	s := testSummary()
+	if !s.Passed() {
+		t.Error("Passed() = false without checks, want true")
+	}
+
+	var l Assertions
+	if err := l.UnmarshalText([]byte("p99(data.update) < 50ms; success_rate > 99.9%")); err != nil {
+		t.Fatal(err)
+	}
+	s.Checks = l.Check(s)
+	if !s.Passed() {
+		t.Errorf("Passed() = false with checks %+v, want true", s.Checks)
+	}
+
+	if err := l.UnmarshalText([]byte("p99(data.update) < 50ms; timeouts == 0")); err != nil {
+		t.Fatal(err)
+	}
+	s.Checks = l.Check(s)
+	if s.Passed() {
+		t.Errorf("Passed() = true with checks %+v, want false", s.Checks)
+	}
+
+	var out bytes.Buffer
+	printChecks(&out, s.Checks)
+	for _, want := range []string{
+		"[PASS] p99(data.update) < 50ms (actual 40ms)",
+		"[FAIL] timeouts == 0 (actual 2)",
+		"1 of 2 SLA checks failed",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("printChecks output %q lacks %q", out.String(), want)
+		}
+	}
+}
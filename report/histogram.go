@@ -0,0 +1,164 @@
+package report
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Sub-buckets per power of two; gives under 1.6% relative error.
+const (
+	subBuckets     = 128
+	halfSubBuckets = subBuckets / 2
+	// Covers latencies up to 2^40 µs (~12 days).
+	maxShift   = 40
+	numBuckets = subBuckets + maxShift*halfSubBuckets
+)
+
+// Histogram is a log-linear latency histogram with microsecond resolution,
+// in the spirit of HdrHistogram: constant memory, cheap to record into and
+// accurate enough for tail percentiles.
+type Histogram struct {
+	mu     sync.Mutex
+	counts [numBuckets]uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func bucketIndex(us uint64) int {
+	if us < subBuckets {
+		return int(us)
+	}
+	shift := bits.Len64(us) - 7
+	if shift > maxShift {
+		return numBuckets - 1
+	}
+	top := us >> uint(shift)
+	return subBuckets + (shift-1)*halfSubBuckets + int(top-halfSubBuckets)
+}
+
+// bucketUpper returns the highest value, in µs, that falls into bucket i.
+func bucketUpper(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	shift := (i-subBuckets)/halfSubBuckets + 1
+	top := uint64((i-subBuckets)%halfSubBuckets + halfSubBuckets)
+	return (top+1)<<uint(shift) - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[bucketIndex(uint64(d/time.Microsecond))]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Quantile returns the latency at quantile q (0..1).
+func (h *Histogram) Quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.quantile(q)
+}
+
+func (h *Histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := time.Duration(bucketUpper(i)) * time.Microsecond
+			if v > h.max {
+				return h.max
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// Snapshot summarizes the histogram under a single lock.
+func (h *Histogram) Snapshot() Latency {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	l := Latency{
+		Min:  h.min,
+		Max:  h.max,
+		P50:  h.quantile(0.50),
+		P90:  h.quantile(0.90),
+		P99:  h.quantile(0.99),
+		P999: h.quantile(0.999),
+	}
+	if h.count > 0 {
+		l.Mean = h.sum / time.Duration(h.count)
+	}
+	return l
+}
+
+// Merge adds every sample recorded in o into h.
+func (h *Histogram) Merge(o *Histogram) {
+	o.mu.Lock()
+	counts, count, sum, min, max := o.counts, o.count, o.sum, o.min, o.max
+	o.mu.Unlock()
+	if count == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, c := range counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || min < h.min {
+		h.min = min
+	}
+	if max > h.max {
+		h.max = max
+	}
+	h.count += count
+	h.sum += sum
+}
+
+// Since returns the samples recorded into h after it looked like earlier,
+// a copy taken before. Min and max are not tracked per sample, so the
+// result keeps h's max as an upper bound and has no min.
+func (h *Histogram) Since(earlier *Histogram) *Histogram {
+	earlier.mu.Lock()
+	counts, count, sum := earlier.counts, earlier.count, earlier.sum
+	earlier.mu.Unlock()
+
+	d := new(Histogram)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, c := range h.counts {
+		d.counts[i] = c - counts[i]
+	}
+	d.count = h.count - count
+	d.sum = h.sum - sum
+	d.max = h.max
+	return d
+}
@@ -0,0 +1,125 @@
+package report
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// maxRelativeError is the precision the sub-buckets give: a bucket spans
+// 1/halfSubBuckets of the smallest value it holds.
+const maxRelativeError = 1.0 / halfSubBuckets
+
+func TestBucketBounds(t *testing.T) {
+	for i := 0; i < numBuckets-1; i++ {
+		upper := bucketUpper(i)
+		if got := bucketIndex(upper); got != i {
+			t.Fatalf("bucketIndex(bucketUpper(%d) = %d) = %d, want %d", i, upper, got, i)
+		}
+		if got := bucketIndex(upper + 1); got != i+1 {
+			t.Fatalf("bucketIndex(%d) = %d, want the next bucket %d", upper+1, got, i+1)
+		}
+		if i >= subBuckets {
+			lower := bucketUpper(i-1) + 1
+			if width := upper - lower + 1; float64(width)/float64(lower) > maxRelativeError {
+				t.Fatalf("bucket %d holds %d..%d, wider than %.2f%% of its lower bound", i, lower, upper, maxRelativeError*100)
+			}
+		}
+	}
+	for _, us := range []uint64{1 << 47, 1 << 60, math.MaxUint64} {
+		if got := bucketIndex(us); got != numBuckets-1 {
+			t.Errorf("bucketIndex(%d) = %d, want the overflow bucket %d", us, got, numBuckets-1)
+		}
+	}
+}
+
+func TestBucketIndexEdges(t *testing.T) {
+	tests := []struct {
+		us   uint64
+		want int
+	}{
+		{0, 0},
+		{1, 1},
+		{subBuckets - 1, subBuckets - 1},
+		{subBuckets, subBuckets},
+		{subBuckets + 1, subBuckets},
+		{subBuckets + 2, subBuckets + 1},
+		{2*subBuckets - 1, subBuckets + halfSubBuckets - 1},
+		{2 * subBuckets, subBuckets + halfSubBuckets},
+		{2*subBuckets + 3, subBuckets + halfSubBuckets},
+		{2*subBuckets + 4, subBuckets + halfSubBuckets + 1},
+	}
+	for _, tt := range tests {
+		if got := bucketIndex(tt.us); got != tt.want {
+			t.Errorf("bucketIndex(%d) = %d, want %d", tt.us, got, tt.want)
+		}
+	}
+}
+
+func TestQuantilePrecision(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var h Histogram
+	samples := make([]time.Duration, 100000)
+	for i := range samples {
+		// Log-uniform from 1µs to about 100s, to cover every magnitude.
+		samples[i] = time.Duration(math.Exp(rng.Float64()*math.Log(1e8))) * time.Microsecond
+		h.Record(samples[i])
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	for _, q := range []float64{0.01, 0.25, 0.5, 0.9, 0.99, 0.999, 1} {
+		want := samples[int(math.Ceil(q*float64(len(samples))))-1]
+		got := h.Quantile(q)
+		if got < want || float64(got-want) > float64(want)*maxRelativeError+float64(time.Microsecond) {
+			t.Errorf("Quantile(%v) = %v, want %v within %.2f%%", q, got, want, maxRelativeError*100)
+		}
+	}
+	if got := h.Quantile(1); got != samples[len(samples)-1] {
+		t.Errorf("Quantile(1) = %v, want the max %v", got, samples[len(samples)-1])
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b, all Histogram
+	for i := 1; i <= 1000; i++ {
+		d := time.Duration(i) * time.Millisecond
+		if i%3 == 0 {
+			a.Record(d)
+		} else {
+			b.Record(d)
+		}
+		all.Record(d)
+	}
+
+	var merged Histogram
+	merged.Merge(&a)
+	merged.Merge(&b)
+	merged.Merge(new(Histogram))
+	if merged.Count() != all.Count() {
+		t.Fatalf("merged count = %d, want %d", merged.Count(), all.Count())
+	}
+	if got, want := merged.Snapshot(), all.Snapshot(); got != want {
+		t.Errorf("merged snapshot = %+v, want %+v", got, want)
+	}
+}
+
+func TestHistogramSince(t *testing.T) {
+	var h, earlier Histogram
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	earlier.Merge(&h)
+	for i := 0; i < 100; i++ {
+		h.Record(time.Second)
+	}
+
+	d := h.Since(&earlier)
+	if d.Count() != 100 {
+		t.Fatalf("Since count = %d, want 100", d.Count())
+	}
+	if got := d.Quantile(0.01); math.Abs(float64(got-time.Second)) > float64(time.Second)*maxRelativeError {
+		t.Errorf("Since Quantile(0.01) = %v, want about 1s", got)
+	}
+}
@@ -0,0 +1,127 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type peerCounters struct {
+	latency                    Histogram
+	requests, errors, timeouts uint64
+
+	rtt         Histogram
+	watchdogs   uint64
+	failures    uint64
+	transitions map[string]uint64
+}
+
+// Peer is the traffic and health of one OCS peer over the run: the
+// requests routed to it, its watchdog round trips and failures and the
+// state changes of its connections.
+type Peer struct {
+	Address          string            `json:"address"`
+	Requests         uint64            `json:"requests"`
+	Errors           uint64            `json:"errors"`
+	Timeouts         uint64            `json:"timeouts"`
+	Latency          Latency           `json:"latency"`
+	Watchdogs        uint64            `json:"watchdogs"`
+	WatchdogFailures uint64            `json:"watchdog_failures"`
+	WatchdogRTT      Latency           `json:"watchdog_rtt"`
+	Transitions      map[string]uint64 `json:"transitions"`
+}
+
+// peer returns the counters of address; r.mu must be held.
+func (r *Recorder) peer(address string) *peerCounters {
+	c, ok := r.peers[address]
+	if !ok {
+		c = &peerCounters{transitions: make(map[string]uint64)}
+		r.peers[address] = c
+	}
+	return c
+}
+
+func (c *peerCounters) record(s Sample) {
+	c.requests++
+	if s.Answered {
+		c.latency.Record(s.Latency)
+	}
+	if s.Timeout {
+		c.timeouts++
+	} else if s.Err != nil {
+		c.errors++
+	}
+}
+
+// RecordWatchdog adds one DWR sent to the peer at address. A non-nil err
+// marks it failed; rtt is only sampled for answered ones.
+func (r *Recorder) RecordWatchdog(address string, rtt time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.peer(address)
+	c.watchdogs++
+	if err != nil {
+		c.failures++
+		return
+	}
+	c.rtt.Record(rtt)
+}
+
+// RecordPeerState counts a peer connection moving between states.
+func (r *Recorder) RecordPeerState(address, from, to string) {
+	r.mu.Lock()
+	r.peer(address).transitions[from+"->"+to]++
+	r.mu.Unlock()
+}
+
+// peerSummary snapshots the peers by address; r.mu must be held.
+func (r *Recorder) peerSummary() []Peer {
+	var peers []Peer
+	for address, c := range r.peers {
+		peers = append(peers, Peer{
+			Address:          address,
+			Requests:         c.requests,
+			Errors:           c.errors,
+			Timeouts:         c.timeouts,
+			Latency:          c.latency.Snapshot(),
+			Watchdogs:        c.watchdogs,
+			WatchdogFailures: c.failures,
+			WatchdogRTT:      c.rtt.Snapshot(),
+			Transitions:      copyCounts(c.transitions),
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Address < peers[j].Address })
+	return peers
+}
+
+func printPeers(w io.Writer, peers []Peer) {
+	if len(peers) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "peer\trequests\terrors\ttimeouts\tp50\tp99\tdwr\tfailed\trtt p50\trtt p99\ttransitions")
+	for _, p := range peers {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%v\t%v\t%d\t%d\t%v\t%v\t%s\n",
+			p.Address, p.Requests, p.Errors, p.Timeouts, round(p.Latency.P50), round(p.Latency.P99),
+			p.Watchdogs, p.WatchdogFailures, round(p.WatchdogRTT.P50), round(p.WatchdogRTT.P99),
+			formatCounts(p.Transitions))
+	}
+	tw.Flush()
+}
+
+func formatCounts(counts map[string]uint64) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%d", name, counts[name])
+	}
+	return strings.Join(parts, " ")
+}
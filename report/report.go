@@ -0,0 +1,417 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"load-test/models"
+)
+
+// Latency holds the percentiles of one histogram.
+type Latency struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+type counters struct {
+	latency  Histogram
+	sent     atomic.Uint64
+	errors   atomic.Uint64
+	timeouts atomic.Uint64
+}
+
+// Sample is the outcome of a single request.
+type Sample struct {
+	Type models.MessageType
+	// Peer is the address of the OCS peer the request went to, if any.
+	Peer     string
+	Latency  time.Duration
+	Answered bool
+	// Timeout marks a request that got no answer in time; it is counted
+	// apart from protocol and result errors.
+	Timeout bool
+	// ResultCode is the CCA Result-Code (or Experimental-Result-Code).
+	ResultCode uint32
+	// ServiceResultCodes are the per-MSCC Result-Codes of the CCA.
+	ServiceResultCodes []uint32
+	Err                error
+}
+
+// Recorder collects per-message-type latencies and outcomes for a run.
+// It is safe for concurrent use.
+type Recorder struct {
+	start time.Time
+	types sync.Map // models.MessageType -> *counters
+	// reauth holds the time from each RAR to the CCR-U it triggered.
+	reauth Histogram
+
+	mu                 sync.Mutex
+	resultCodes        map[uint32]uint64
+	serviceResultCodes map[uint32]uint64
+	sessions           map[string]uint64
+	droppedSessions    uint64
+	exhaustedSessions  map[string]uint64
+	abortedSessions    map[string]uint64
+	drainedSessions    map[string]uint64
+	peers              map[string]*peerCounters
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		start:              time.Now(),
+		resultCodes:        make(map[uint32]uint64),
+		serviceResultCodes: make(map[uint32]uint64),
+		sessions:           make(map[string]uint64),
+		exhaustedSessions:  make(map[string]uint64),
+		abortedSessions:    make(map[string]uint64),
+		drainedSessions:    make(map[string]uint64),
+		peers:              make(map[string]*peerCounters),
+	}
+}
+
+func (r *Recorder) counters(t models.MessageType) *counters {
+	if c, ok := r.types.Load(t); ok {
+		return c.(*counters)
+	}
+	c, _ := r.types.LoadOrStore(t, new(counters))
+	return c.(*counters)
+}
+
+// Record adds one request. Latency is only sampled for requests that got
+// an answer; a non-nil Err marks the request as failed.
+func (r *Recorder) Record(s Sample) {
+	c := r.counters(s.Type)
+	c.sent.Add(1)
+	if s.Answered {
+		c.latency.Record(s.Latency)
+	}
+	if s.Timeout {
+		c.timeouts.Add(1)
+	} else if s.Err != nil {
+		c.errors.Add(1)
+	}
+
+	if s.Peer == "" && s.ResultCode == 0 && len(s.ServiceResultCodes) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s.Peer != "" {
+		r.peer(s.Peer).record(s)
+	}
+	if s.ResultCode != 0 {
+		r.resultCodes[s.ResultCode]++
+	}
+	for _, code := range s.ServiceResultCodes {
+		r.serviceResultCodes[code]++
+	}
+}
+
+// Totals sums the requests of every message type recorded so far.
+type Totals struct {
+	Sent     uint64
+	Errors   uint64
+	Timeouts uint64
+	Latency  *Histogram
+}
+
+func (r *Recorder) Totals() Totals {
+	t := Totals{Latency: new(Histogram)}
+	r.types.Range(func(_, v interface{}) bool {
+		c := v.(*counters)
+		t.Sent += c.sent.Load()
+		t.Errors += c.errors.Load()
+		t.Timeouts += c.timeouts.Load()
+		t.Latency.Merge(&c.latency)
+		return true
+	})
+	return t
+}
+
+// SessionCount is the number of account sessions started so far.
+func (r *Recorder) SessionCount() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n uint64
+	for _, c := range r.sessions {
+		n += c
+	}
+	return n
+}
+
+// RecordSession counts one account session started with the named flow.
+func (r *Recorder) RecordSession(name string) {
+	r.mu.Lock()
+	r.sessions[name]++
+	r.mu.Unlock()
+}
+
+// RecordDroppedSession counts a session that was not started because the
+// concurrency limit was reached.
+func (r *Recorder) RecordDroppedSession() {
+	r.mu.Lock()
+	r.droppedSessions++
+	r.mu.Unlock()
+}
+
+// RecordExhausted counts a session that ended because its credit ran out.
+func (r *Recorder) RecordExhausted(reason string) {
+	r.mu.Lock()
+	r.exhaustedSessions[reason]++
+	r.mu.Unlock()
+}
+
+// RecordAborted counts a session of service that the OCS aborted with an
+// ASR.
+func (r *Recorder) RecordAborted(service string) {
+	r.mu.Lock()
+	r.abortedSessions[service]++
+	r.mu.Unlock()
+}
+
+// RecordDrained counts a session of service terminated because the run
+// was stopped.
+func (r *Recorder) RecordDrained(service string) {
+	r.mu.Lock()
+	r.drainedSessions[service]++
+	r.mu.Unlock()
+}
+
+// RecordReAuth counts one RAR that was followed by a CCR-U after latency.
+func (r *Recorder) RecordReAuth(latency time.Duration) {
+	r.reauth.Record(latency)
+}
+
+type Stats struct {
+	Type        string  `json:"type"`
+	Count       uint64  `json:"count"`
+	Errors      uint64  `json:"errors"`
+	Timeouts    uint64  `json:"timeouts"`
+	ErrorRate   float64 `json:"error_rate"`
+	TimeoutRate float64 `json:"timeout_rate"`
+	Throughput  float64 `json:"throughput"`
+	Latency     Latency `json:"latency"`
+}
+
+type Summary struct {
+	// StoppedBy says what ended the run early, if anything did.
+	StoppedBy          string            `json:"stopped_by,omitempty"`
+	Elapsed            time.Duration     `json:"elapsed"`
+	Types              []Stats           `json:"types"`
+	Services           []Stats           `json:"services"`
+	Total              Stats             `json:"total"`
+	Sessions           map[string]uint64 `json:"sessions"`
+	DroppedSessions    uint64            `json:"dropped_sessions"`
+	ExhaustedSessions  map[string]uint64 `json:"exhausted_sessions"`
+	AbortedSessions    map[string]uint64 `json:"aborted_sessions"`
+	DrainedSessions    map[string]uint64 `json:"drained_sessions"`
+	States             SessionStates     `json:"session_states"`
+	ReAuth             ReAuth            `json:"reauth"`
+	Peers              []Peer            `json:"peers"`
+	ResultCodes        map[uint32]uint64 `json:"result_codes"`
+	ServiceResultCodes map[uint32]uint64 `json:"service_result_codes"`
+	Checks             []Check           `json:"checks,omitempty"`
+}
+
+// ReAuth sums up the RARs answered during the run; Latency runs from the
+// RAR to the CCR-U it triggered.
+type ReAuth struct {
+	Count   uint64  `json:"count"`
+	Latency Latency `json:"latency"`
+}
+
+// Summary snapshots everything recorded so far.
+func (r *Recorder) Summary() *Summary {
+	elapsed := time.Since(r.start)
+	s := &Summary{Elapsed: elapsed}
+
+	total := new(aggregate)
+	var services []models.Service
+	byService := make(map[models.Service]*aggregate)
+	for _, t := range models.MessageTypes {
+		v, ok := r.types.Load(t)
+		if !ok {
+			continue
+		}
+		c := v.(*counters)
+		st := newStats(t.String(), c.sent.Load(), c.errors.Load(), c.timeouts.Load(), &c.latency, elapsed)
+		s.Types = append(s.Types, st)
+		total.add(st, &c.latency)
+
+		if byService[t.Service] == nil {
+			byService[t.Service] = new(aggregate)
+			services = append(services, t.Service)
+		}
+		byService[t.Service].add(st, &c.latency)
+	}
+	for _, service := range services {
+		s.Services = append(s.Services, byService[service].stats(string(service), elapsed))
+	}
+	s.Total = total.stats("total", elapsed)
+	s.ReAuth = ReAuth{Count: r.reauth.Count(), Latency: r.reauth.Snapshot()}
+
+	r.mu.Lock()
+	s.ResultCodes = copyCodes(r.resultCodes)
+	s.ServiceResultCodes = copyCodes(r.serviceResultCodes)
+	s.Sessions = copyCounts(r.sessions)
+	s.ExhaustedSessions = copyCounts(r.exhaustedSessions)
+	s.AbortedSessions = copyCounts(r.abortedSessions)
+	s.DrainedSessions = copyCounts(r.drainedSessions)
+	s.DroppedSessions = r.droppedSessions
+	s.Peers = r.peerSummary()
+	r.mu.Unlock()
+	return s
+}
+
+// aggregate sums the stats of several message types.
+type aggregate struct {
+	latency                Histogram
+	sent, errors, timeouts uint64
+}
+
+func (a *aggregate) add(st Stats, h *Histogram) {
+	a.latency.Merge(h)
+	a.sent += st.Count
+	a.errors += st.Errors
+	a.timeouts += st.Timeouts
+}
+
+func (a *aggregate) stats(name string, elapsed time.Duration) Stats {
+	return newStats(name, a.sent, a.errors, a.timeouts, &a.latency, elapsed)
+}
+
+func copyCounts(src map[string]uint64) map[string]uint64 {
+	dst := make(map[string]uint64, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+func copyCodes(src map[uint32]uint64) map[uint32]uint64 {
+	dst := make(map[uint32]uint64, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+func newStats(name string, sent, errs, timeouts uint64, h *Histogram, elapsed time.Duration) Stats {
+	st := Stats{
+		Type:     name,
+		Count:    sent,
+		Errors:   errs,
+		Timeouts: timeouts,
+		Latency:  h.Snapshot(),
+	}
+	if sent > 0 {
+		st.ErrorRate = float64(errs) / float64(sent)
+		st.TimeoutRate = float64(timeouts) / float64(sent)
+	}
+	if elapsed > 0 {
+		st.Throughput = float64(sent) / elapsed.Seconds()
+	}
+	return st
+}
+
+// Print writes the summary as a human-readable table.
+func (s *Summary) Print(w io.Writer) {
+	fmt.Fprintf(w, "\nRun summary (%v)\n", s.Elapsed.Round(time.Millisecond))
+	if s.StoppedBy != "" {
+		fmt.Fprintf(w, "Run stopped early by %s\n", s.StoppedBy)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "type\tcount\terrors\terr%\ttimeouts\ttimeout%\treq/s\tp50\tp90\tp99\tp99.9\tmax\t")
+	for _, st := range s.Types {
+		printStats(tw, st)
+	}
+	printStats(tw, s.Total)
+	if len(s.Services) > 1 {
+		fmt.Fprintln(tw, "\t\t\t\t\t\t\t\t\t\t\t\t")
+		fmt.Fprintln(tw, "service\tcount\terrors\terr%\ttimeouts\ttimeout%\treq/s\tp50\tp90\tp99\tp99.9\tmax\t")
+		for _, st := range s.Services {
+			printStats(tw, st)
+		}
+	}
+	tw.Flush()
+
+	printCounts(w, "Sessions", s.Sessions)
+	printCounts(w, "Sessions ended on exhausted credit", s.ExhaustedSessions)
+	printCounts(w, "Sessions aborted by the OCS", s.AbortedSessions)
+	printCounts(w, "Sessions terminated on shutdown", s.DrainedSessions)
+	printSessionStates(w, s.States)
+	if s.DroppedSessions > 0 {
+		fmt.Fprintf(w, "Dropped sessions (concurrency limit): %d\n", s.DroppedSessions)
+	}
+	if s.ReAuth.Count > 0 {
+		l := s.ReAuth.Latency
+		fmt.Fprintf(w, "RARs: %d, RAR to CCR-U p50 %v p99 %v max %v\n",
+			s.ReAuth.Count, round(l.P50), round(l.P99), round(l.Max))
+	}
+
+	printCodes(w, "Result-Code", s.ResultCodes)
+	printCodes(w, "MSCC Result-Code", s.ServiceResultCodes)
+	printPeers(w, s.Peers)
+	printChecks(w, s.Checks)
+}
+
+func printCodes(w io.Writer, title string, codes map[uint32]uint64) {
+	if len(codes) == 0 {
+		return
+	}
+	keys := make([]uint32, 0, len(codes))
+	for code := range codes {
+		keys = append(keys, code)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	fmt.Fprintf(w, "%s:", title)
+	for _, code := range keys {
+		fmt.Fprintf(w, " %d=%d", code, codes[code])
+	}
+	fmt.Fprintln(w)
+}
+
+func printCounts(w io.Writer, title string, counts map[string]uint64) {
+	if len(counts) == 0 {
+		return
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "%s:", title)
+	for _, name := range names {
+		fmt.Fprintf(w, " %s=%d", name, counts[name])
+	}
+	fmt.Fprintln(w)
+}
+
+func printStats(w io.Writer, st Stats) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\t%d\t%.2f\t%.1f\t%v\t%v\t%v\t%v\t%v\t\n",
+		st.Type, st.Count, st.Errors, st.ErrorRate*100, st.Timeouts, st.TimeoutRate*100, st.Throughput,
+		round(st.Latency.P50), round(st.Latency.P90), round(st.Latency.P99),
+		round(st.Latency.P999), round(st.Latency.Max))
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+// WriteJSON writes the summary as JSON; durations are in nanoseconds.
+func (s *Summary) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
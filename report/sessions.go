@@ -0,0 +1,41 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// SessionStates counts the sessions of the run by the state they ended in.
+// Init and Active sessions were still open when the run ended.
+type SessionStates struct {
+	Init       int    `json:"init"`
+	Active     int    `json:"active"`
+	Terminated uint64 `json:"terminated"`
+	Failed     uint64 `json:"failed"`
+	// NeverTerminated samples the open sessions, oldest first.
+	NeverTerminated []OpenSession `json:"never_terminated,omitempty"`
+}
+
+// OpenSession is a session that never terminated. Age runs from its
+// CCR-I and Idle from its last request.
+type OpenSession struct {
+	AccountID string        `json:"account_id"`
+	SessionID string        `json:"session_id"`
+	State     string        `json:"state"`
+	Age       time.Duration `json:"age"`
+	Idle      time.Duration `json:"idle"`
+}
+
+func printSessionStates(w io.Writer, s SessionStates) {
+	open := s.Init + s.Active
+	if open == 0 && s.Terminated == 0 && s.Failed == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Session states: terminated=%d failed=%d never terminated=%d (init=%d active=%d)\n",
+		s.Terminated, s.Failed, open, s.Init, s.Active)
+	for _, o := range s.NeverTerminated {
+		fmt.Fprintf(w, "  %s %s %s, opened %v ago, idle %v\n",
+			o.State, o.AccountID, o.SessionID, o.Age.Round(time.Millisecond), o.Idle.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	log "github.com/sirupsen/logrus"
+	"load-test/config"
+	"load-test/diameter"
+	"load-test/ocs"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serveOCS runs the built-in fake OCS until interrupted:
+//
+//	load-test serve-ocs -listen :3868 -latency normal:5ms,2ms -error-rate 0.01
+func serveOCS(args []string) {
+	settings := ocs.DefaultSettings()
+	fs := flag.NewFlagSet("serve-ocs", flag.ExitOnError)
+	fs.StringVar(&settings.Network, "network", settings.Network, "Transport to listen on: tcp, sctp or tls")
+	fs.StringVar(&settings.Addr, "listen", settings.Addr, "Address to listen on")
+	fs.StringVar(&settings.CertFile, "tls-cert", "", "Server certificate file for tls")
+	fs.StringVar(&settings.KeyFile, "tls-key", "", "Server private key file for tls")
+	fs.StringVar(&settings.OriginHost, "origin-host", settings.OriginHost, "Origin-Host sent in CEA and CCA")
+	fs.StringVar(&settings.OriginRealm, "origin-realm", settings.OriginRealm, "Origin-Realm sent in CEA and CCA")
+	resultCode := fs.Uint("result-code", uint(settings.ResultCode), "Result-Code for answered CCRs")
+	fs.Uint64Var(&settings.GrantedOctets, "granted-octets", settings.GrantedOctets, "CC-Total-Octets granted per MSCC (0: none)")
+	grantedTime := fs.Uint("granted-time", uint(settings.GrantedTime), "CC-Time in seconds granted per MSCC (0: none)")
+	validityTime := fs.Uint("validity-time", uint(settings.ValidityTime), "Validity-Time in seconds per MSCC (0: none)")
+	latency := fs.String("latency", "", "Answer latency: 5ms, uniform:1ms,10ms, normal:5ms,2ms or exp:5ms")
+	fs.Float64Var(&settings.ErrorRate, "error-rate", 0, "Fraction of CCRs answered with -error-code")
+	errorCode := fs.Uint("error-code", uint(settings.ErrorCode), "Result-Code used for injected errors")
+	fs.Float64Var(&settings.DropRate, "drop-rate", 0, "Fraction of CCRs left unanswered")
+	finalUnitAfter := fs.Uint("final-unit-after", 0, "Send Final-Unit-Indication from this CC-Request-Number on (0: never)")
+	fs.StringVar(&settings.FinalUnitAction, "final-unit-action", settings.FinalUnitAction, "Final-Unit-Action: terminate, redirect or restrict")
+	fs.StringVar(&settings.RedirectAddress, "redirect-address", settings.RedirectAddress, "Redirect-Server-Address for the redirect action")
+	creditLimitAfter := fs.Uint("credit-limit-after", 0, "Answer updates with 4012 from this CC-Request-Number on (0: never)")
+	fs.DurationVar(&settings.ReAuthAfter, "rar-after", 0, "Send a Re-Auth-Request this long after each CCR-I (0: never)")
+	fs.DurationVar(&settings.AbortAfter, "asr-after", 0, "Send an Abort-Session-Request this long after each CCR-I (0: never)")
+	fs.Parse(args)
+
+	var err error
+	settings.Latency, err = ocs.ParseLatency(*latency)
+	if err != nil {
+		log.Fatalf("invalid -latency: %v", err)
+	}
+	settings.ResultCode = uint32(*resultCode)
+	settings.GrantedTime = uint32(*grantedTime)
+	settings.ValidityTime = uint32(*validityTime)
+	settings.ErrorCode = uint32(*errorCode)
+	settings.FinalUnitAfter = uint32(*finalUnitAfter)
+	settings.CreditLimitAfter = uint32(*creditLimitAfter)
+
+	server, err := ocs.New(settings)
+	if err != nil {
+		log.Fatalf("invalid settings: %v", err)
+	}
+	addr, err := server.Listen()
+	if err != nil {
+		log.Fatalf("unable to listen on %s: %v", settings.Addr, err)
+	}
+	log.Infof("fake OCS listening on %s/%s, latency %v", settings.Network, addr, settings.Latency)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		server.Close()
+	}()
+	go func() {
+		for range time.Tick(10 * time.Second) {
+			st := server.Stats()
+			log.Infof("ocs: received %d answered %d errors %d dropped %d rars %d asrs %d", st.Received, st.Answered, st.Errors, st.Dropped, st.ReAuths, st.Aborts)
+		}
+	}()
+
+	if err := server.Serve(); err != nil {
+		log.Infof("fake OCS stopped: %v", err)
+	}
+}
+
+// startEmbeddedOCS serves the fake OCS on a loopback port and points the
+// run's peer at it.
+func startEmbeddedOCS(cfg *config.Config) *ocs.Server {
+	settings := ocs.DefaultSettings()
+	settings.Network = cfg.Peer.Network
+	if settings.Network == diameter.TransportTLS {
+		log.Fatalf("the embedded OCS does not serve tls; run serve-ocs -network tls with a certificate instead")
+	}
+	settings.Addr = "127.0.0.1:0"
+	server, err := ocs.New(settings)
+	if err != nil {
+		log.Fatalf("unable to start embedded OCS: %v", err)
+	}
+	addr, err := server.Listen()
+	if err != nil {
+		log.Fatalf("unable to start embedded OCS: %v", err)
+	}
+	go server.Serve()
+	cfg.Peer.Address = addr.String()
+	cfg.Peer.Peers, cfg.Peer.BackupPeers = nil, nil
+	log.Infof("embedded OCS listening on %s", addr)
+	return server
+}
@@ -0,0 +1,191 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"load-test/batch"
+	"load-test/models"
+)
+
+// Columns of the CSV sink, named like those of the results table.
+var csvHeader = []string{
+	"account_id", "session_id", "message_type", "request_number",
+	"sent_at", "received_at", "latency_us", "result_code",
+	"granted_time", "granted_total_octets", "granted_input_octets", "granted_output_octets",
+	"error",
+}
+
+// fileQueueSize is the number of results a file sink holds for its writer
+// before it drops them.
+const fileQueueSize = 1 << 15
+
+// file is a result file written through a buffer by the goroutine of its
+// queue. As with the postgres sink, when the disk falls behind, results are
+// dropped and counted so recording does not throttle the load.
+type file struct {
+	*batch.Queue
+	f   *os.File
+	buf *bufio.Writer
+	// encode writes one result to buf; flush, if set, empties the
+	// encoder's own buffer into buf.
+	encode func(r models.Result) error
+	flush  func() error
+
+	// err is the first write error; later results are not written.
+	err  error
+	once sync.Once
+}
+
+func createFile(path string) (*file, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &file{f: f, buf: bufio.NewWriterSize(f, 1<<16)}, nil
+}
+
+// start runs the writer goroutine once the sink set encode.
+func (f *file) start() {
+	f.Queue = batch.New(fileQueueSize, 1, 0, f.write)
+}
+
+func (f *file) write(results []models.Result) error {
+	if f.err != nil {
+		return f.err
+	}
+	for _, r := range results {
+		if err := f.encode(r); err != nil {
+			f.err = err
+			log.Errorf("unable to write results to %s: %v", f.f.Name(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the queued results, flushes and closes the file.
+func (f *file) Close() error {
+	f.once.Do(func() {
+		f.err = f.Queue.Close()
+		if f.flush != nil && f.err == nil {
+			f.err = f.flush()
+		}
+		if err := f.buf.Flush(); err != nil && f.err == nil {
+			f.err = err
+		}
+		if err := f.f.Close(); err != nil && f.err == nil {
+			f.err = err
+		}
+		if dropped := f.Dropped(); dropped > 0 {
+			log.Warnf("%d of %d results were not written to %s", dropped, dropped+f.Written(), f.f.Name())
+		}
+	})
+	return errors.Wrapf(f.err, "unable to write %s", f.f.Name())
+}
+
+// CSV writes results as CSV with a header line.
+type CSV struct {
+	*file
+	w *csv.Writer
+}
+
+func NewCSV(path string) (*CSV, error) {
+	f, err := createFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &CSV{file: f, w: csv.NewWriter(f.buf)}
+	if err := s.w.Write(csvHeader); err != nil {
+		f.f.Close()
+		return nil, err
+	}
+	f.encode = s.writeResult
+	f.flush = func() error {
+		s.w.Flush()
+		return s.w.Error()
+	}
+	f.start()
+	return s, nil
+}
+
+func (s *CSV) writeResult(r models.Result) error {
+	var received, latency, code string
+	if !r.Received.IsZero() {
+		received = r.Received.Format(time.RFC3339Nano)
+		latency = strconv.FormatInt(r.Latency.Microseconds(), 10)
+	}
+	if r.ResultCode != 0 {
+		code = strconv.FormatUint(uint64(r.ResultCode), 10)
+	}
+	return s.w.Write([]string{
+		r.AccountID.String(), r.SessionID, r.Type.String(), strconv.FormatUint(uint64(r.RequestNumber), 10),
+		r.Sent.Format(time.RFC3339Nano), received, latency, code,
+		strconv.FormatUint(uint64(r.GrantedTime), 10),
+		strconv.FormatUint(r.GrantedTotalOctets, 10),
+		strconv.FormatUint(r.GrantedInputOctets, 10),
+		strconv.FormatUint(r.GrantedOutputOctets, 10),
+		r.Err,
+	})
+}
+
+// JSONL writes results as one JSON object per line.
+type JSONL struct {
+	*file
+	enc *json.Encoder
+}
+
+type jsonResult struct {
+	AccountID           string     `json:"account_id"`
+	SessionID           string     `json:"session_id"`
+	MessageType         string     `json:"message_type"`
+	RequestNumber       uint32     `json:"request_number"`
+	SentAt              time.Time  `json:"sent_at"`
+	ReceivedAt          *time.Time `json:"received_at,omitempty"`
+	LatencyUS           *int64     `json:"latency_us,omitempty"`
+	ResultCode          uint32     `json:"result_code,omitempty"`
+	GrantedTime         uint32     `json:"granted_time"`
+	GrantedTotalOctets  uint64     `json:"granted_total_octets"`
+	GrantedInputOctets  uint64     `json:"granted_input_octets"`
+	GrantedOutputOctets uint64     `json:"granted_output_octets"`
+	Error               string     `json:"error,omitempty"`
+}
+
+func NewJSONL(path string) (*JSONL, error) {
+	f, err := createFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &JSONL{file: f, enc: json.NewEncoder(f.buf)}
+	f.encode = s.writeResult
+	f.start()
+	return s, nil
+}
+
+func (s *JSONL) writeResult(r models.Result) error {
+	v := jsonResult{
+		AccountID:           r.AccountID.String(),
+		SessionID:           r.SessionID,
+		MessageType:         r.Type.String(),
+		RequestNumber:       r.RequestNumber,
+		SentAt:              r.Sent,
+		ResultCode:          r.ResultCode,
+		GrantedTime:         r.GrantedTime,
+		GrantedTotalOctets:  r.GrantedTotalOctets,
+		GrantedInputOctets:  r.GrantedInputOctets,
+		GrantedOutputOctets: r.GrantedOutputOctets,
+		Error:               r.Err,
+	}
+	if !r.Received.IsZero() {
+		latency := r.Latency.Microseconds()
+		v.ReceivedAt, v.LatencyUS = &r.Received, &latency
+	}
+	return s.enc.Encode(v)
+}
@@ -0,0 +1,157 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"load-test/models"
+)
+
+var (
+	sent     = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	received = sent.Add(1500 * time.Microsecond)
+)
+
+// testResults are an answered CCR and one that timed out.
+var testResults = []models.Result{
+	{
+		AccountID: models.NewAccountID(1), SessionID: "s1", Type: models.DataInit, RequestNumber: 0,
+		Sent: sent, Received: received, Latency: received.Sub(sent), ResultCode: 2001,
+		GrantedTime: 60, GrantedTotalOctets: 1000, GrantedInputOctets: 400, GrantedOutputOctets: 600,
+	},
+	{
+		AccountID: models.NewAccountID(2), SessionID: "s2", Type: models.VoiceCallingUpdate, RequestNumber: 3,
+		Sent: sent, Err: "timeout",
+	},
+}
+
+func TestCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.csv")
+	s, err := NewCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range testResults {
+		s.Write(r)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		csvHeader,
+		{"001", "s1", "data.init", "0", "2026-01-02T03:04:05Z", "2026-01-02T03:04:05.0015Z", "1500", "2001", "60", "1000", "400", "600", ""},
+		{"002", "s2", "voice_calling.update", "3", "2026-01-02T03:04:05Z", "", "", "", "0", "0", "0", "0", "timeout"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+	if s.Written() != 2 || s.Dropped() != 0 {
+		t.Errorf("written, dropped = %d, %d, want 2, 0", s.Written(), s.Dropped())
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	s, err := NewJSONL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range testResults {
+		s.Write(r)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("line %d: %v", len(lines)+1, err)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{
+			"account_id": "001", "session_id": "s1", "message_type": "data.init", "request_number": 0.0,
+			"sent_at": "2026-01-02T03:04:05Z", "received_at": "2026-01-02T03:04:05.0015Z", "latency_us": 1500.0,
+			"result_code": 2001.0, "granted_time": 60.0, "granted_total_octets": 1000.0,
+			"granted_input_octets": 400.0, "granted_output_octets": 600.0,
+		},
+		{
+			"account_id": "002", "session_id": "s2", "message_type": "voice_calling.update", "request_number": 3.0,
+			"sent_at": "2026-01-02T03:04:05Z", "granted_time": 0.0, "granted_total_octets": 0.0,
+			"granted_input_octets": 0.0, "granted_output_octets": 0.0, "error": "timeout",
+		},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %v, want %v", lines, want)
+	}
+}
+
+func TestFileDropsAfterClose(t *testing.T) {
+	s, err := NewJSONL(filepath.Join(t.TempDir(), "results.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write(testResults[0])
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s.Write(testResults[1])
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Written() != 1 || s.Dropped() != 1 {
+		t.Errorf("written, dropped = %d, %d, want 1, 1", s.Written(), s.Dropped())
+	}
+}
+
+func TestFileDropsAfterWriteError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	s, err := NewCSV("/dev/full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough results to overflow the file buffer, so that a write fails
+	// before Close.
+	const n = 2000
+	for i := 0; i < n; i++ {
+		s.Write(testResults[0])
+	}
+	err = s.Close()
+	if err == nil || !strings.Contains(err.Error(), "unable to write /dev/full") {
+		t.Fatalf("Close() = %v, want a write error", err)
+	}
+	if s.Written()+s.Dropped() != n || s.Dropped() == 0 {
+		t.Errorf("written, dropped = %d, %d, want some of %d dropped", s.Written(), s.Dropped(), n)
+	}
+}
@@ -0,0 +1,41 @@
+package sink
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"load-test/db"
+)
+
+// Postgres stores results under a new run in the results table.
+type Postgres struct {
+	*db.Writer
+	db *db.DB
+}
+
+func NewPostgres(dsn, description string) (*Postgres, error) {
+	d, err := db.New(dsn)
+	if err != nil {
+		return nil, err
+	}
+	runID, err := d.StartRun(description)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	fmt.Printf("Storing results as run %s\n", runID)
+	return &Postgres{Writer: d.NewWriter(runID, db.DefaultBatchSize, db.DefaultFlushInterval), db: d}, nil
+}
+
+// Close writes the results still queued and stamps the end of the run.
+func (p *Postgres) Close() error {
+	err := p.Writer.Close()
+	if dropped := p.Dropped(); dropped > 0 {
+		log.Warnf("%d of %d results were not stored", dropped, dropped+p.Written())
+	}
+	if ferr := p.db.FinishRun(p.RunID()); ferr != nil {
+		log.Errorf("run %s: %v", p.RunID(), ferr)
+	}
+	p.db.Close()
+	return err
+}
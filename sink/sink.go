@@ -0,0 +1,83 @@
+package sink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"load-test/models"
+)
+
+// ResultSink receives the outcome of every request of a run. Write is
+// called from many goroutines and must not hold up the load.
+type ResultSink interface {
+	Write(r models.Result)
+	// Close stores what is still buffered; nothing is written after it.
+	Close() error
+}
+
+// Options are what sinks need besides their spec.
+type Options struct {
+	// DSN is the Postgres connection string of the postgres sink.
+	DSN string
+	// Description is stored with the run by the postgres sink.
+	Description string
+}
+
+// Open builds the sinks of spec, a comma-separated list of csv:FILE,
+// jsonl:FILE and postgres.
+func Open(spec string, opts Options) (ResultSink, error) {
+	var sinks multi
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		s, err := open(entry, opts)
+		if err != nil {
+			sinks.Close()
+			return nil, errors.Wrapf(err, "results sink %s", entry)
+		}
+		sinks = append(sinks, s)
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return sinks, nil
+}
+
+func open(entry string, opts Options) (ResultSink, error) {
+	kind, path, _ := strings.Cut(entry, ":")
+	switch kind {
+	case "csv", "jsonl":
+		if path == "" {
+			return nil, fmt.Errorf("want %s:FILE", kind)
+		}
+		if kind == "csv" {
+			return NewCSV(path)
+		}
+		return NewJSONL(path)
+	case "postgres":
+		return NewPostgres(opts.DSN, opts.Description)
+	}
+	return nil, fmt.Errorf("unknown sink %q: want csv:FILE, jsonl:FILE or postgres", kind)
+}
+
+// multi writes every result to each of its sinks.
+type multi []ResultSink
+
+func (m multi) Write(r models.Result) {
+	for _, s := range m {
+		s.Write(r)
+	}
+}
+
+func (m multi) Close() error {
+	var first error
+	for _, s := range m {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}